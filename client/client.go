@@ -0,0 +1,130 @@
+// Package client is a typed Go client of the price notification service.
+// Methods correspond to the operations of the OpenAPI specification served from /openapi.json
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"test_avito/config"
+)
+
+const apiPrefix = "/api/v1"
+
+// Error returned by the service. Code is one of the machine readable codes of the specification
+type Error struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%d %s: %s (%s)", e.Status, e.Code, e.Message, e.Field)
+	}
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+type Client struct {
+	BaseUrl    string
+	HttpClient *http.Client
+}
+
+// Creating a new client of the service available at baseUrl, for example http://127.0.0.1:8080
+func New(baseUrl string) *Client {
+	return &Client{
+		BaseUrl:    strings.TrimRight(baseUrl, "/"),
+		HttpClient: http.DefaultClient,
+	}
+}
+
+type subscriptionRequest struct {
+	Url   string `json:"url"`
+	Email string `json:"email,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// Subscribe email to price changes of the ad
+func (c *Client) Subscribe(ctx context.Context, adUrl string, email string) (config.Subscription, error) {
+	var sub config.Subscription
+	err := c.do(ctx, "POST", "/subscribe", nil, subscriptionRequest{Url: adUrl, Email: email}, &sub)
+	return sub, err
+}
+
+// Remove subscription to the ad. Token is the token of the subscriber's feed given after the confirmation
+func (c *Client) Unsubscribe(ctx context.Context, adUrl string, token string) error {
+	return c.do(ctx, "POST", "/unsubscribe", nil, subscriptionRequest{Url: adUrl, Token: token}, nil)
+}
+
+// List all subscriptions of the owner of the feed token
+func (c *Client) List(ctx context.Context, token string) ([]config.Subscription, error) {
+	var subs []config.Subscription
+	err := c.do(ctx, "GET", "/subscriptions", url.Values{"token": {token}}, nil, &subs)
+	return subs, err
+}
+
+// Confirm email by the hash from confirmation letter
+func (c *Client) Confirm(ctx context.Context, hash string) error {
+	return c.do(ctx, "GET", "/confirm", url.Values{"hash": {hash}}, nil, nil)
+}
+
+// Recorded prices of the ad, the oldest first
+func (c *Client) History(ctx context.Context, adUrl string) ([]config.PricePoint, error) {
+	var points []config.PricePoint
+	err := c.do(ctx, "GET", "/history", url.Values{"url": {adUrl}}, nil, &points)
+	return points, err
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values,
+	body interface{}, result interface{}) error {
+	endpoint := c.BaseUrl + apiPrefix + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{Status: resp.StatusCode}
+		if err = json.NewDecoder(resp.Body).Decode(apiErr); err != nil {
+			apiErr.Code = "unknown"
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+	"test_avito/src/controllers"
+	"test_avito/src/services"
+)
+
+const listingHTML = `window.dataLayer = [{"dynx_prodid":1791027290,"dynx_price":8792009,"dynx_category":"avtomobili"}];`
+
+func newTestService(t *testing.T) (*Client, *httptest.Server, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Subscription handler queries the database from several goroutines
+	mock.MatchExpectationsInOrder(false)
+
+	listing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, listingHTML)
+	}))
+	t.Cleanup(listing.Close)
+
+	scp := controllers.NewScrapper(&services.DB{DB: db}, config.Config{})
+	scp.Client = listing.Client()
+	env := controllers.EnvironmentNotification{
+		Db:  scp.Db,
+		Scp: scp,
+	}
+
+	server := httptest.NewServer(controllers.NewRouter(&env))
+	t.Cleanup(server.Close)
+
+	return New(server.URL), listing, mock
+}
+
+func TestSubscribe(t *testing.T) {
+	c, listing, mock := newTestService(t)
+
+	mock.ExpectQuery("SELECT COALESCE\\(bool_or\\(acc_verified\\), false\\)").
+		WithArgs("d_kokin@inbox.ru").
+		WillReturnRows(sqlmock.NewRows([]string{"acc_verified"}).AddRow(true))
+	mock.ExpectQuery("SELECT DISTINCT url FROM subscription").
+		WithArgs("d_kokin@inbox.ru", listing.URL).
+		WillReturnRows(sqlmock.NewRows([]string{"url"}))
+	mock.ExpectExec("INSERT INTO subscription").
+		WithArgs(true, "d_kokin@inbox.ru", 8792009, listing.URL, 0, false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO scrape_job").
+		WithArgs(listing.URL, false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO price_history").
+		WithArgs(listing.URL, 8792009, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	sub, err := c.Subscribe(context.Background(), listing.URL, "d_kokin@inbox.ru")
+	assert.Nil(t, err)
+	assert.Equal(t, config.Subscription{
+		AccVerified: true,
+		Email:       "d_kokin@inbox.ru",
+		Price:       8792009,
+		Url:         listing.URL,
+	}, sub)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSubscribeInvalidEmail(t *testing.T) {
+	c, listing, _ := newTestService(t)
+
+	_, err := c.Subscribe(context.Background(), listing.URL, "badEmail")
+	apiErr, ok := err.(*Error)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+	assert.Equal(t, "invalid_email", apiErr.Code)
+	assert.Equal(t, "email", apiErr.Field)
+}
+
+func TestUnsubscribeNotFound(t *testing.T) {
+	c, listing, mock := newTestService(t)
+
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WithArgs("feed-token").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("d_kokin@inbox.ru"))
+	mock.ExpectExec("DELETE FROM subscription").
+		WithArgs("d_kokin@inbox.ru", listing.URL).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := c.Unsubscribe(context.Background(), listing.URL, "feed-token")
+	apiErr, ok := err.(*Error)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+	assert.Equal(t, "subscription_not_found", apiErr.Code)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	c, listing, mock := newTestService(t)
+
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WithArgs("feed-token").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("d_kokin@inbox.ru"))
+	mock.ExpectExec("DELETE FROM subscription").
+		WithArgs("d_kokin@inbox.ru", listing.URL).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM scrape_job").
+		WithArgs(listing.URL).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.Nil(t, c.Unsubscribe(context.Background(), listing.URL+"/", "feed-token"))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestList(t *testing.T) {
+	c, _, mock := newTestService(t)
+
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WithArgs("feed-token").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("d_kokin@inbox.ru"))
+	rows := sqlmock.NewRows([]string{"acc_verified", "email", "price", "url", "ad_id", "is_search",
+		"title", "image_url", "location", "seller", "published_at"}).
+		AddRow(true, "d_kokin@inbox.ru", 100, "https://www.avito.ru/moskva/bmw_1791027290", 1791027290, false,
+			"BMW M5, 2019", "https://img.avito.st/1.jpg", "Москва", "BMW Major", nil).
+		AddRow(true, "d_kokin@inbox.ru", 200, "https://www.avito.ru/moskva/audi_1791027291", 1791027291, false,
+			nil, nil, nil, nil, nil)
+	mock.ExpectQuery("SELECT s.acc_verified, s.email, s.price, s.url, COALESCE\\(s.ad_id, 0\\), COALESCE\\(s.is_search, false\\)").
+		WithArgs("d_kokin@inbox.ru").
+		WillReturnRows(rows)
+
+	subs, err := c.List(context.Background(), "feed-token")
+	assert.Nil(t, err)
+	assert.Len(t, subs, 2)
+	assert.Equal(t, int64(1791027291), subs[1].AdId)
+	assert.Equal(t, &config.ListingInfo{
+		Title:    "BMW M5, 2019",
+		ImageUrl: "https://img.avito.st/1.jpg",
+		Location: "Москва",
+		Seller:   "BMW Major",
+	}, subs[0].Info)
+	assert.Nil(t, subs[1].Info)
+}
+
+func TestConfirm(t *testing.T) {
+	c, _, mock := newTestService(t)
+
+	mock.ExpectQuery("SELECT \\* FROM auth_confirmation").
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows([]string{"email", "hash", "deadline"}).
+			AddRow("d_kokin@inbox.ru", "hash", time.Now().Add(time.Hour)))
+	mock.ExpectExec("UPDATE subscription").
+		WithArgs("d_kokin@inbox.ru").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM auth_confirmation").
+		WithArgs("hash").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	assert.Nil(t, c.Confirm(context.Background(), "hash"))
+}
+
+func TestHistory(t *testing.T) {
+	c, _, mock := newTestService(t)
+
+	adUrl := "https://www.avito.ru/moskva/bmw_1791027290"
+	checkedAt := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT url, price, checked_at FROM price_history").
+		WithArgs(adUrl).
+		WillReturnRows(sqlmock.NewRows([]string{"url", "price", "checked_at"}).
+			AddRow(adUrl, 100, checkedAt).
+			AddRow(adUrl, 90, checkedAt.Add(time.Hour)))
+
+	points, err := c.History(context.Background(), "https://m.avito.ru/moskva/bmw_1791027290?utm_source=x")
+	assert.Nil(t, err)
+	assert.Len(t, points, 2)
+	assert.Equal(t, 90, points[1].Price)
+	assert.True(t, checkedAt.Equal(points[0].CheckedAt))
+}
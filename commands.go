@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"test_avito/config"
+	"test_avito/src/controllers"
+	"test_avito/src/logging"
+	"test_avito/src/services"
+)
+
+// Subcommand of the binary. Name can have several words, for example "subscriptions list"
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "start the HTTP and gRPC servers and the scrapper (default)", serveCommand},
+	{"scrape-once", "--url URL: download the page and print the price or the ads found on it", scrapeOnceCommand},
+	{"migrate", "create or update the scheme of the database", migrateCommand},
+	{"subscriptions list", "--email EMAIL: print subscriptions of the email", listSubscriptionsCommand},
+	{"subscriptions add", "--url URL --email EMAIL: subscribe the email without the confirmation letter", addSubscriptionCommand},
+	{"subscriptions remove", "--url URL --email EMAIL: remove the subscription", removeSubscriptionCommand},
+	{"send-test-mail", "--to EMAIL: send a test mail from the account of the service", sendTestMailCommand},
+	{"config validate", "check the config and print its wrong fields", validateConfigCommand},
+}
+
+// Wrong arguments of the command, the usage is printed
+var errUsage = errors.New("wrong arguments")
+
+// The config is taken from --config flag, then from AVITO_CONFIG variable, then from the default file.
+// Without the default file the service runs with the defaults and the environment variables
+const defaultConfigPath = "./config/config.yml"
+
+var configPath string
+
+func resolveConfigPath() string {
+	if configPath != "" {
+		return configPath
+	}
+	if path, _ := os.LookupEnv(config.EnvPrefix + "CONFIG"); path != "" {
+		return path
+	}
+	if _, err := os.Stat(defaultConfigPath); err == nil {
+		return defaultConfigPath
+	}
+	return ""
+}
+
+// Function that runs the command given by the arguments and returns the exit code.
+// Without arguments the service is started
+func runCommand(args []string) int {
+	global := flag.NewFlagSet("server.app", flag.ContinueOnError)
+	global.StringVar(&configPath, "config", "", "path of the config file")
+	global.Usage = func() { printUsage(os.Stderr) }
+	err := global.Parse(args)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+
+	args = global.Args()
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	if args[0] == "help" {
+		printUsage(os.Stdout)
+		return 0
+	}
+
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) < len(words) || strings.Join(args[:len(words)], " ") != cmd.name {
+			continue
+		}
+
+		err := cmd.run(args[len(words):])
+		if err == flag.ErrHelp {
+			return 0
+		}
+		if err == errUsage {
+			fmt.Fprintf(os.Stderr, "Usage: %s %s\n", cmd.name, cmd.usage)
+			return 2
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n", strings.Join(args, " "))
+	printUsage(os.Stderr)
+	return 2
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: server.app [--config FILE] [command]")
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-22s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+// Function that parses flags of the command. Positional arguments are not accepted.
+// --config can also be given after the command
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	flags.SetOutput(os.Stderr)
+	flags.StringVar(&configPath, "config", configPath, "path of the config file")
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return err
+	}
+	if err != nil || flags.NArg() > 0 {
+		return errUsage
+	}
+	return nil
+}
+
+// Function that reads and checks the config and sets up the logger and the mail account by it.
+// Logs go to stderr, so the output of the commands can be piped
+func loadConfig() (config.Config, error) {
+	conf, err := config.Load(resolveConfigPath())
+	if err != nil {
+		return conf, err
+	}
+	err = conf.Validate()
+	if err != nil {
+		return conf, err
+	}
+
+	services.SetMailAccount(conf.Mail)
+	return conf, logging.Setup(conf.Log.Level, conf.Log.Format)
+}
+
+// Function that connects to the database of the config. The scheme is not changed
+func openDatabase(conf config.Config) (*services.DB, error) {
+	db, err := services.NewDB(conf)
+	if err != nil {
+		return nil, fmt.Errorf("database is not available: %w", err)
+	}
+	return db, nil
+}
+
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func serveCommand(args []string) error {
+	if err := parseFlags(flag.NewFlagSet("serve", flag.ContinueOnError), args); err != nil {
+		return err
+	}
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	return serve(conf)
+}
+
+// Result of scrape-once. Price is absent for the search results page
+type scrapeResult struct {
+	Url      string              `json:"url"`
+	IsSearch bool                `json:"is_search"`
+	Price    int                 `json:"price,omitempty"`
+	Listing  *config.ListingInfo `json:"listing,omitempty"`
+	Listings []config.Listing    `json:"listings,omitempty"`
+}
+
+func scrapeOnceCommand(args []string) error {
+	flags := flag.NewFlagSet("scrape-once", flag.ContinueOnError)
+	url := flags.String("url", "", "url of the ad or of the search results page")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *url == "" {
+		return errUsage
+	}
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	// The database is not needed to download the page
+	scp := controllers.NewScrapper(nil, conf)
+	canonical, response, err := scp.ScrapeOnce(context.Background(), *url)
+	if err != nil {
+		return err
+	}
+
+	result := scrapeResult{
+		Url:      canonical,
+		IsSearch: response.IsSearch,
+		Listings: response.Listings,
+	}
+	if !response.IsSearch {
+		result.Price = response.Price
+		if response.Info != (config.ListingInfo{}) {
+			result.Listing = &response.Info
+		}
+	}
+	return printJSON(result)
+}
+
+func migrateCommand(args []string) error {
+	if err := parseFlags(flag.NewFlagSet("migrate", flag.ContinueOnError), args); err != nil {
+		return err
+	}
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase(conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = services.Setup(pathToScheme, db)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Scheme of the database is up to date")
+	return nil
+}
+
+// Function that opens the database and creates the service operations used by the subscriptions commands
+func openEnvironment() (*controllers.EnvironmentNotification, error) {
+	conf, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	db, err := openDatabase(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &controllers.EnvironmentNotification{
+		Db:     db,
+		Scp:    controllers.NewScrapper(db, conf),
+		Limits: controllers.NewSubscribeLimits(conf.Limits),
+	}, nil
+}
+
+func listSubscriptionsCommand(args []string) error {
+	flags := flag.NewFlagSet("subscriptions list", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the subscriber")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errUsage
+	}
+	env, err := openEnvironment()
+	if err != nil {
+		return err
+	}
+
+	subs, err := env.ListSubscriptionsOfEmail(context.Background(), *email)
+	if err != nil {
+		return err
+	}
+	return printJSON(subs)
+}
+
+// Flags of the commands which change one subscription
+func subscriptionFlags(name string, args []string) (controllers.SubscriptionRequest, error) {
+	var req controllers.SubscriptionRequest
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&req.Url, "url", "", "url of the ad or of the search results page")
+	flags.StringVar(&req.Email, "email", "", "email of the subscriber")
+	if err := parseFlags(flags, args); err != nil {
+		return req, err
+	}
+	if req.Url == "" || req.Email == "" {
+		return req, errUsage
+	}
+	return req, nil
+}
+
+func addSubscriptionCommand(args []string) error {
+	req, err := subscriptionFlags("subscriptions add", args)
+	if err != nil {
+		return err
+	}
+	env, err := openEnvironment()
+	if err != nil {
+		return err
+	}
+
+	sub, err := env.SubscribeConfirmed(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	return printJSON(sub)
+}
+
+func removeSubscriptionCommand(args []string) error {
+	req, err := subscriptionFlags("subscriptions remove", args)
+	if err != nil {
+		return err
+	}
+	env, err := openEnvironment()
+	if err != nil {
+		return err
+	}
+
+	err = env.UnsubscribeEmail(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Subscription is removed")
+	return nil
+}
+
+func sendTestMailCommand(args []string) error {
+	flags := flag.NewFlagSet("send-test-mail", flag.ContinueOnError)
+	to := flags.String("to", "", "address of the test mail")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *to == "" {
+		return errUsage
+	}
+	if _, err := loadConfig(); err != nil {
+		return err
+	}
+
+	err := services.SendTestMail(context.Background(), *to)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Test mail is sent to", *to)
+	return nil
+}
+
+func validateConfigCommand(args []string) error {
+	if err := parseFlags(flag.NewFlagSet("config validate", flag.ContinueOnError), args); err != nil {
+		return err
+	}
+	if _, err := loadConfig(); err != nil {
+		return err
+	}
+	if path := resolveConfigPath(); path != "" {
+		fmt.Println("Config", path, "is valid")
+	} else {
+		fmt.Println("Config of the defaults and the environment is valid")
+	}
+	return nil
+}
@@ -1,20 +1,82 @@
 package config
 
 import (
+	"fmt"
 	"gopkg.in/yaml.v3"
 	"os"
 )
 
-func (cfg *Config) LoadFromYaml(file string) {
+// Default values of the fields which are set neither in the file nor in the environment
+func Default() Config {
+	return Config{
+		Scrapper: Scrapper{
+			WorkerCount:            10,
+			ScrapperTimeout:        1,
+			PageDownloadingTimeout: 3000,
+			MaxPageSize:            4096,
+			ProxyHealthUrl:         "https://www.avito.ru/",
+			CanaryWindow:           100,
+			CanaryThreshold:        0.5,
+		},
+		DataBase: DataBase{
+			Driver:  "postgres",
+			Host:    "localhost",
+			Port:    "5432",
+			SslMode: "disable",
+		},
+		Server: Server{
+			Port:     8080,
+			GrpcPort: 9090,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			Endpoint:    "localhost:4318",
+			File:        "traces.json",
+			SampleRatio: 1,
+		},
+		Limits: Limits{
+			IpPerMinute:      10,
+			EmailPerHour:     20,
+			MaxSubscriptions: 50,
+			MaxUnconfirmed:   5,
+			AllowedHosts:     []string{"avito.ru"},
+		},
+	}
+}
+
+// Function that builds the config: the defaults, then the yaml file if the path is not empty,
+// then AVITO_ environment variables. The config is not validated
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		err := cfg.LoadFromYaml(path)
+		if err != nil {
+			return cfg, fmt.Errorf("config %s is not read: %w", path, err)
+		}
+	}
+
+	err := cfg.ApplyEnv(os.LookupEnv)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.applyLegacyEnv(os.LookupEnv)
+	return cfg, nil
+}
+
+// Reading the config from the yaml file. Fields absent in the file keep their values,
+// unknown fields are errors, so misprints are not ignored
+func (cfg *Config) LoadFromYaml(file string) error {
 	f, err := os.Open(file)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer f.Close()
 
 	decoder := yaml.NewDecoder(f)
-	err = decoder.Decode(cfg)
-	if err != nil {
-		panic(err)
-	}
+	decoder.KnownFields(true)
+	return decoder.Decode(cfg)
 }
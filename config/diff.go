@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Value shown instead of the secrets in the logs
+const hiddenValue = "***"
+
+// Changed field of the config. Values of the secrets are hidden
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Function that finds the fields which differ in two configs. Fields are named by the path
+// in the yaml file and go in the order of the file
+func Diff(old Config, new Config) []Change {
+	var changes []Change
+	oldSections, newSections := reflect.ValueOf(old), reflect.ValueOf(new)
+	for i := 0; i < oldSections.NumField(); i++ {
+		oldSection, newSection := oldSections.Field(i), newSections.Field(i)
+		sectionName := yamlName(oldSections.Type().Field(i))
+		for j := 0; j < oldSection.NumField(); j++ {
+			oldValue, newValue := oldSection.Field(j).Interface(), newSection.Field(j).Interface()
+			if reflect.DeepEqual(oldValue, newValue) {
+				continue
+			}
+
+			name := yamlName(oldSection.Type().Field(j))
+			change := Change{Field: sectionName + "." + name, Old: fmt.Sprint(oldValue), New: fmt.Sprint(newValue)}
+			if isSecret(name) {
+				change.Old, change.New = hiddenValue, hiddenValue
+			}
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+func isSecret(name string) bool {
+	return strings.Contains(name, "password") || strings.Contains(name, "token")
+}
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffHidesSecrets(t *testing.T) {
+	old := Default()
+	new := Default()
+	new.WorkerCount = 20
+	new.Proxies = []string{"http://a:3128"}
+	new.DataBase.Password = "s3cret"
+	new.Server.AdminToken = "token"
+
+	assert.Equal(t, []Change{
+		{Field: "crawler.worker_count", Old: "10", New: "20"},
+		{Field: "crawler.proxies", Old: "[]", New: "[http://a:3128]"},
+		{Field: "data_base.password", Old: "***", New: "***"},
+		{Field: "server.admin_token", Old: "***", New: "***"},
+	}, Diff(old, new))
+	assert.Empty(t, Diff(old, Default()))
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Prefix of the environment variables which override fields of the config
+const EnvPrefix = "AVITO_"
+
+// Suffix of the variable with the path of the file that keeps the value, for secrets
+const fileEnvSuffix = "_FILE"
+
+// Function that overrides fields of the config by environment variables. The name of the variable is
+// the path of the field in the yaml file, for example AVITO_DATA_BASE_PASSWORD for data_base.password.
+// The value can be read from the file named by the variable with _FILE suffix, for example
+// AVITO_DATA_BASE_PASSWORD_FILE=/run/secrets/db_password. Lists are separated by commas
+func (cfg *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	var errs ValidationError
+	sections := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		sectionName := yamlName(sections.Type().Field(i))
+		for j := 0; j < section.NumField(); j++ {
+			field := sectionName + "." + yamlName(section.Type().Field(j))
+			variable := EnvPrefix + strings.ToUpper(strings.ReplaceAll(field, ".", "_"))
+
+			value, found, err := lookupEnv(lookup, variable)
+			if err == nil && found {
+				err = setField(section.Field(j), value)
+			}
+			if err != nil {
+				errs = append(errs, FieldError{Field: field, Message: err.Error()})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Value of the variable or of the file named by the variable with _FILE suffix
+func lookupEnv(lookup func(string) (string, bool), variable string) (string, bool, error) {
+	value, found := lookup(variable)
+	path, fromFile := lookup(variable + fileEnvSuffix)
+	if !fromFile {
+		return value, found, nil
+	}
+	if found {
+		return "", false, fmt.Errorf("only one of %s and %s must be set", variable, variable+fileEnvSuffix)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %v", variable+fileEnvSuffix, err)
+	}
+	// Editors add the line break to the end of the file
+	return strings.TrimRight(string(content), "\r\n"), true, nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		number, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(number)
+	case reflect.Float64:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetFloat(number)
+	case reflect.Bool:
+		flag, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(flag)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("can not be set from the environment")
+	}
+	return nil
+}
+
+func yamlName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("yaml"), ",")[0]
+}
+
+// Before the config had the mail section the account was taken from service_mail and password variables,
+// they are still used if the account is not set
+func (cfg *Config) applyLegacyEnv(lookup func(string) (string, bool)) {
+	if cfg.Mail.Address == "" {
+		cfg.Mail.Address, _ = lookup("service_mail")
+	}
+	if cfg.Mail.Password == "" {
+		cfg.Mail.Password, _ = lookup("password")
+	}
+}
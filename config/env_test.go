@@ -0,0 +1,76 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeEnv(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func TestApplyEnvOverridesFields(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "db_password")
+	assert.Nil(t, os.WriteFile(secret, []byte("s3cret\n"), 0600))
+
+	cfg := Default()
+	err := cfg.ApplyEnv(fakeEnv(map[string]string{
+		"AVITO_CRAWLER_WORKER_COUNT":     "3",
+		"AVITO_CRAWLER_PROXIES":          "http://a:3128, socks5://b:1080",
+		"AVITO_CRAWLER_CANARY_THRESHOLD": "0.8",
+		"AVITO_TRACING_INSECURE":         "true",
+		"AVITO_DATA_BASE_HOST":           "db",
+		"AVITO_DATA_BASE_PASSWORD_FILE":  secret,
+		"AVITO_MAIL_ADDRESS":             "service@example.com",
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, cfg.WorkerCount)
+	assert.Equal(t, []string{"http://a:3128", "socks5://b:1080"}, cfg.Proxies)
+	assert.Equal(t, 0.8, cfg.CanaryThreshold)
+	assert.True(t, cfg.Tracing.Insecure)
+	assert.Equal(t, "db", cfg.DataBase.Host)
+	assert.Equal(t, "s3cret", cfg.DataBase.Password)
+	assert.Equal(t, "service@example.com", cfg.Mail.Address)
+	// Fields without variables keep the defaults
+	assert.Equal(t, 8080, cfg.Server.Port)
+}
+
+func TestApplyEnvNamesWrongFields(t *testing.T) {
+	cfg := Default()
+	err := cfg.ApplyEnv(fakeEnv(map[string]string{
+		"AVITO_SERVER_PORT":             "http",
+		"AVITO_MAIL_PASSWORD":           "one",
+		"AVITO_MAIL_PASSWORD_FILE":      "/run/secrets/two",
+		"AVITO_DATA_BASE_USERNAME_FILE": "/not/existing/file",
+	}))
+	validationErr, ok := err.(ValidationError)
+	assert.True(t, ok)
+
+	var fields []string
+	for _, field := range validationErr {
+		fields = append(fields, field.Field)
+	}
+	assert.Equal(t, []string{"data_base.username", "server.port", "mail.password"}, fields)
+	assert.Contains(t, err.Error(), `server.port: "http" is not an integer`)
+}
+
+func TestLoadWithoutFile(t *testing.T) {
+	t.Setenv("AVITO_DATA_BASE_USERNAME", "docker")
+	t.Setenv("AVITO_DATA_BASE_NAME", "testbase")
+	t.Setenv("service_mail", "legacy@example.com")
+
+	cfg, err := Load("")
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+	assert.Equal(t, 10, cfg.WorkerCount)
+	assert.Equal(t, "legacy@example.com", cfg.Mail.Address)
+
+	_, err = Load("not_existing.yml")
+	assert.NotNil(t, err)
+}
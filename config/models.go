@@ -11,9 +11,17 @@ type AuthConfirmation struct {
 
 // Scrapper options
 type Scrapper struct {
-	WorkerCount            int   `yaml:"worker_count"`
-	ScrapperTimeout        int64 `yaml:"timeout"`
-	PageDownloadingTimeout int64 `yaml:"page_timeout"`
+	WorkerCount            int      `yaml:"worker_count"`
+	ScrapperTimeout        int64    `yaml:"timeout"`
+	MinInterval            int64    `yaml:"min_interval"`
+	MaxInterval            int64    `yaml:"max_interval"`
+	PageDownloadingTimeout int64    `yaml:"page_timeout"`
+	MaxPageSize            int64    `yaml:"max_page_size"`
+	Proxies                []string `yaml:"proxies"`
+	ProxyHealthUrl         string   `yaml:"proxy_health_url"`
+	CanaryWindow           int      `yaml:"canary_window"`
+	CanaryThreshold        float64  `yaml:"canary_threshold"`
+	OperatorEmail          string   `yaml:"operator_email"`
 }
 
 // DataBase options
@@ -29,15 +37,143 @@ type DataBase struct {
 
 // Server options
 type Server struct {
-	Port int `yaml:"port"`
+	Port       int    `yaml:"port"`
+	GrpcPort   int    `yaml:"grpc_port"`
+	AdminToken string `yaml:"admin_token"`
+}
+
+// Mail account of the service used to send notifications
+type Mail struct {
+	Address  string `yaml:"address"`
+	Password string `yaml:"password"`
+}
+
+// Limits of the subscription requests against the abuse of the service, zero disables the limit.
+// Only urls of the allowed hosts and their subdomains are accepted, an empty list allows any host
+type Limits struct {
+	IpPerMinute      int      `yaml:"ip_per_minute"`
+	EmailPerHour     int      `yaml:"email_per_hour"`
+	MaxSubscriptions int      `yaml:"max_subscriptions"`
+	MaxUnconfirmed   int      `yaml:"max_unconfirmed"`
+	AllowedHosts     []string `yaml:"allowed_hosts"`
+}
+
+// Logging options
+type Log struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Tracing options. Exporter is none, stdout, file or otlp
+type Tracing struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	File        string  `yaml:"file"`
+	SampleRatio float64 `yaml:"sample_ratio"`
 }
 
 // Main structure for the service
 type Subscription struct {
-	AccVerified bool
-	Email       string
-	Price       int
-	Url         string
+	AccVerified bool         `json:"acc_verified"`
+	Email       string       `json:"email"`
+	Price       int          `json:"price"`
+	Url         string       `json:"url"`
+	AdId        int64        `json:"ad_id,omitempty"`
+	IsSearch    bool         `json:"is_search,omitempty"`
+	Info        *ListingInfo `json:"listing,omitempty"`
+}
+
+// Description of the ad taken from its page. Fields which were not found on the page are empty
+type ListingInfo struct {
+	Title       string     `json:"title,omitempty"`
+	ImageUrl    string     `json:"image_url,omitempty"`
+	Location    string     `json:"location,omitempty"`
+	Seller      string     `json:"seller,omitempty"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+}
+
+// Ad found on the search results page
+type Listing struct {
+	AdId  int64  `json:"ad_id"`
+	Url   string `json:"url"`
+	Price int    `json:"price"`
+}
+
+// Price of the ad observed by the scrapper at some moment
+type PricePoint struct {
+	Url       string    `json:"url"`
+	Price     int       `json:"price"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Change of the ad price taken from the price history
+type PriceChangeRecord struct {
+	Url       string
+	OldPrice  int
+	NewPrice  int
+	ChangedAt time.Time
+}
+
+// Kinds of events published by the scrapper
+const (
+	EventPriceChange    = "price_change"
+	EventListingRemoved = "listing_removed"
+	EventScrapeError    = "scrape_error"
+	EventNewListings    = "new_listings"
+	EventScrapeBlocked  = "scrape_blocked"
+)
+
+// Event found by the scrapper. Emails are the subscribers of the ad, they are used only for filtering
+type Event struct {
+	Id       uint64    `json:"id"`
+	Kind     string    `json:"kind"`
+	Url      string    `json:"url"`
+	OldPrice int       `json:"old_price,omitempty"`
+	NewPrice int       `json:"new_price,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Listings []Listing `json:"listings,omitempty"`
+	Emails   []string  `json:"-"`
+	Time     time.Time `json:"time"`
+}
+
+// Results of the check of the url recorded in the queue
+const (
+	CheckOk      = "ok"
+	CheckFailed  = "failed"
+	CheckRemoved = "removed"
+	CheckSkipped = "skipped"
+)
+
+// Result of one check of the url. Error is empty if the check is done
+type CheckResult struct {
+	Status string
+	Error  string
+}
+
+// State of the url in the queue of the scrapper. Status is empty until the first check.
+// Failures is the number of failed checks in a row
+type ListingStatus struct {
+	Url         string     `json:"url"`
+	IsSearch    bool       `json:"is_search"`
+	Subscribers int        `json:"subscribers"`
+	Status      string     `json:"status,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastCheckAt *time.Time `json:"last_check_at,omitempty"`
+	NextCheckAt time.Time  `json:"next_check_at"`
+	Failures    int        `json:"failures"`
+	LeasedBy    string     `json:"leased_by,omitempty"`
+}
+
+// Notification which was not delivered. Channel is email or alert
+type FailedNotification struct {
+	Id       int64     `json:"id"`
+	Channel  string    `json:"channel"`
+	Email    string    `json:"email"`
+	Url      string    `json:"url,omitempty"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
 }
 
 // Convenient structure for launching the service
@@ -45,15 +181,28 @@ type Config struct {
 	Scrapper `yaml:"crawler"`
 	DataBase `yaml:"data_base"`
 	Server   `yaml:"server"`
+	Log      `yaml:"log"`
+	Tracing  `yaml:"tracing"`
+	Mail     `yaml:"mail"`
+	Limits   `yaml:"limits"`
 }
 
-// Convenient structure for checking price updates
+// Convenient structure for checking price updates.
+// ActiveDays is the number of days of the last month when the price changed or new ads were found
 type CheckPriceRequest struct {
-	OldPrice int
-	Url      string
+	OldPrice    int
+	Url         string
+	IsSearch    bool
+	Subscribers int
+	ActiveDays  int
 }
 
+// Result of the page downloading. Listings are filled if the page is a search results page,
+// otherwise Info describes the ad
 type GetPriceResponse struct {
-	Price int
-	Error error
+	Price    int
+	Error    error
+	IsSearch bool
+	Listings []Listing
+	Info     ListingInfo
 }
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"test_avito/utils"
+)
+
+// Wrong value of the config field. Field is the path of the field in the yaml file, for example crawler.worker_count
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// All wrong fields of the config
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, field := range e {
+		lines = append(lines, field.Error())
+	}
+	return "config is not valid:\n  " + strings.Join(lines, "\n  ")
+}
+
+// Function that checks all fields of the config. Returns ValidationError with every wrong field
+func (cfg *Config) Validate() error {
+	var errs ValidationError
+	fail := func(field string, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if cfg.WorkerCount < 1 {
+		fail("crawler.worker_count", "must be at least 1")
+	}
+	if cfg.ScrapperTimeout < 1 {
+		fail("crawler.timeout", "must be at least 1 minute")
+	}
+	if cfg.MinInterval < 0 {
+		fail("crawler.min_interval", "must not be negative")
+	}
+	if cfg.MaxInterval < 0 {
+		fail("crawler.max_interval", "must not be negative")
+	}
+	if cfg.MaxInterval != 0 && cfg.MaxInterval < cfg.MinInterval {
+		fail("crawler.max_interval", "must not be less than min_interval")
+	}
+	if cfg.PageDownloadingTimeout < 1 {
+		fail("crawler.page_timeout", "must be at least 1 ms")
+	}
+	if cfg.MaxPageSize < 0 {
+		fail("crawler.max_page_size", "must not be negative")
+	}
+	for i, raw := range cfg.Proxies {
+		proxyUrl, err := url.Parse(raw)
+		if err != nil || proxyUrl.Host == "" {
+			fail(fmt.Sprintf("crawler.proxies[%d]", i), "is not a valid url")
+			continue
+		}
+		switch proxyUrl.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			fail(fmt.Sprintf("crawler.proxies[%d]", i), "scheme must be http, https, socks5 or socks5h")
+		}
+	}
+	if cfg.CanaryWindow < 0 {
+		fail("crawler.canary_window", "must not be negative")
+	}
+	if cfg.CanaryThreshold < 0 || cfg.CanaryThreshold > 1 {
+		fail("crawler.canary_threshold", "must be from 0 to 1")
+	}
+	if cfg.OperatorEmail != "" && utils.CheckEmail(cfg.OperatorEmail) != nil {
+		fail("crawler.operator_email", "is not a valid email")
+	}
+
+	if cfg.DataBase.Driver != "postgres" {
+		fail("data_base.driver", "must be postgres")
+	}
+	required := []struct{ field, value string }{
+		{"data_base.username", cfg.DataBase.Username},
+		{"data_base.host", cfg.DataBase.Host},
+		{"data_base.port", cfg.DataBase.Port},
+		{"data_base.name", cfg.DataBase.Name},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			fail(r.field, "must be set")
+		}
+	}
+
+	if cfg.Server.Port < 1 || cfg.Server.Port > 65535 {
+		fail("server.port", "must be from 1 to 65535")
+	}
+	if cfg.GrpcPort < 0 || cfg.GrpcPort > 65535 {
+		fail("server.grpc_port", "must be from 0 to 65535")
+	}
+	if cfg.GrpcPort != 0 && cfg.GrpcPort == cfg.Server.Port {
+		fail("server.grpc_port", "must differ from port")
+	}
+
+	if cfg.Mail.Address != "" && utils.CheckEmail(cfg.Mail.Address) != nil {
+		fail("mail.address", "is not a valid email")
+	}
+
+	limits := []struct {
+		field string
+		value int
+	}{
+		{"limits.ip_per_minute", cfg.IpPerMinute},
+		{"limits.email_per_hour", cfg.EmailPerHour},
+		{"limits.max_subscriptions", cfg.MaxSubscriptions},
+		{"limits.max_unconfirmed", cfg.MaxUnconfirmed},
+	}
+	for _, l := range limits {
+		if l.value < 0 {
+			fail(l.field, "must not be negative")
+		}
+	}
+	for i, host := range cfg.AllowedHosts {
+		if host == "" || strings.ContainsAny(host, "/:@ ") {
+			fail(fmt.Sprintf("limits.allowed_hosts[%d]", i), "must be a host name")
+		}
+	}
+
+	switch strings.ToLower(cfg.Log.Level) {
+	case "", "debug", "info", "warn", "warning", "error":
+	default:
+		fail("log.level", "must be debug, info, warn or error")
+	}
+	switch strings.ToLower(cfg.Log.Format) {
+	case "", "json", "logfmt":
+	default:
+		fail("log.format", "must be json or logfmt")
+	}
+
+	switch strings.ToLower(cfg.Tracing.Exporter) {
+	case "", "none", "stdout", "otlp":
+	case "file":
+		if cfg.Tracing.File == "" {
+			fail("tracing.file", "must be set for the file exporter")
+		}
+	default:
+		fail("tracing.exporter", "must be none, stdout, file or otlp")
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		fail("tracing.sample_ratio", "must be from 0 to 1")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigOfRepositoryIsValid(t *testing.T) {
+	var cfg Config
+	assert.Nil(t, cfg.LoadFromYaml("config.yml"))
+	assert.Nil(t, cfg.Validate())
+}
+
+func TestValidateNamesWrongFields(t *testing.T) {
+	var cfg Config
+	assert.Nil(t, cfg.LoadFromYaml("config.yml"))
+	cfg.WorkerCount = 0
+	cfg.Proxies = []string{"ftp://proxy:21"}
+	cfg.CanaryThreshold = 2
+	cfg.DataBase.Host = ""
+	cfg.MaxUnconfirmed = -1
+	cfg.AllowedHosts = []string{"https://avito.ru"}
+	cfg.Log.Level = "verbose"
+
+	err := cfg.Validate()
+	validationErr, ok := err.(ValidationError)
+	assert.True(t, ok)
+
+	var fields []string
+	for _, field := range validationErr {
+		fields = append(fields, field.Field)
+	}
+	assert.Equal(t, []string{"crawler.worker_count", "crawler.proxies[0]", "crawler.canary_threshold",
+		"data_base.host", "limits.max_unconfirmed", "limits.allowed_hosts[0]", "log.level"}, fields)
+	assert.Contains(t, err.Error(), "crawler.worker_count: must be at least 1")
+}
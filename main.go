@@ -1,41 +1,74 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"github.com/gorilla/mux"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
 	"test_avito/config"
 	"test_avito/src/controllers"
+	"test_avito/src/rpc"
 	"test_avito/src/services"
+	"test_avito/src/tracing"
 )
 
-var (
-	pathToConfig = "./config/config.yml"
-	pathToScheme = "./src/db/init.sql"
-)
+var pathToScheme = "./src/db/init.sql"
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
 
 func main() {
-	var conf config.Config
-	conf.LoadFromYaml(pathToConfig)
+	os.Exit(runCommand(os.Args[1:]))
+}
 
-	db := services.ConnectToDB(conf)
+// Starting the HTTP and gRPC servers and the scrapper. It is the command by default
+func serve(conf config.Config) error {
+	shutdownTracing, err := tracing.Setup(conf.Tracing)
+	if err != nil {
+		return fmt.Errorf("wrong tracing options: %w", err)
+	}
+	defer shutdownTracing(context.Background())
 
-	services.Setup(pathToScheme, db)
-	log.Println("Database is ready")
+	// The database is connected in the background, so /healthz and /readyz answer while it starts
+	db, err := services.OpenDB(conf)
+	if err != nil {
+		return fmt.Errorf("database is not opened: %w", err)
+	}
 
 	scp := controllers.NewScrapper(db, conf)
 	env := controllers.EnvironmentNotification{
-		Db:  db,
-		Scp: scp,
+		Db:         db,
+		Scp:        scp,
+		AdminToken: conf.Server.AdminToken,
+		Limits:     controllers.NewSubscribeLimits(conf.Limits),
 	}
 
-	r := mux.NewRouter()
+	r := controllers.NewRouter(&env)
+	go newReloader(resolveConfigPath(), &env, conf).watch()
 
-	r.HandleFunc("/subscribe", env.SubscriptionHandler).Methods("POST")
-	r.HandleFunc("/confirm", env.ConfirmEmailHandler).Methods("GET")
+	go func() {
+		services.WaitForDB(db)
+		services.SetupWithRetry(pathToScheme, db)
+		slog.Info("Database is ready")
 
-	go env.Scp.Start()
-	log.Println("scrapper is launched")
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", conf.Server.Port), r))
+		slog.Info("scrapper is launched")
+		env.Scp.Start()
+	}()
+
+	if conf.Server.GrpcPort != 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", conf.Server.GrpcPort))
+		if err != nil {
+			return fmt.Errorf("gRPC port is not opened: %w", err)
+		}
+		grpcServer := rpc.NewServer(&controllers.GrpcServer{Env: &env})
+		go func() {
+			fatal("gRPC server is stopped", grpcServer.Serve(lis))
+		}()
+		slog.Info("gRPC server is launched", "port", conf.Server.GrpcPort)
+	}
+	return fmt.Errorf("HTTP server is stopped: %w", http.ListenAndServe(fmt.Sprintf(":%d", conf.Server.Port), r))
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"test_avito/config"
+	"test_avito/src/controllers"
+	"test_avito/src/logging"
+)
+
+// How often the config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// Fields of the config which are used only on the start, their changes need the restart
+var restartFields = []string{"data_base.", "server.", "tracing.", "crawler.proxies", "crawler.proxy_health_url"}
+
+// Reloading of the config on SIGHUP and on changes of the config file
+type reloader struct {
+	path    string
+	env     *controllers.EnvironmentNotification
+	current config.Config
+	modTime time.Time
+	size    int64
+}
+
+func newReloader(path string, env *controllers.EnvironmentNotification, current config.Config) *reloader {
+	r := &reloader{path: path, env: env, current: current}
+	r.modTime, r.size = r.stat()
+	return r
+}
+
+// Function that waits for SIGHUP and polls the config file. Reloads are done one by one
+func (r *reloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			r.reload("signal")
+		case <-ticker.C:
+			if r.path == "" {
+				continue
+			}
+			modTime, size := r.stat()
+			if modTime.Equal(r.modTime) && size == r.size {
+				continue
+			}
+			r.reload("file")
+		}
+	}
+}
+
+func (r *reloader) stat() (time.Time, int64) {
+	if r.path == "" {
+		return time.Time{}, 0
+	}
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
+
+// Function that loads the config again and applies it. A wrong config is not applied,
+// the service keeps working with the previous one
+func (r *reloader) reload(reason string) {
+	r.modTime, r.size = r.stat()
+	log := slog.With("reason", reason, "path", r.path)
+
+	conf, err := config.Load(r.path)
+	if err == nil {
+		err = conf.Validate()
+	}
+	if err != nil {
+		log.Error("Config is not reloaded", "error", err)
+		return
+	}
+
+	changes := config.Diff(r.current, conf)
+	if len(changes) == 0 {
+		log.Info("Config is reloaded without changes")
+		return
+	}
+
+	err = logging.Setup(conf.Log.Level, conf.Log.Format)
+	if err != nil {
+		log.Error("Config is not reloaded", "error", err)
+		return
+	}
+	// The running service still uses the old values of these fields, so they are kept
+	// and the next reloads report them as waiting for the restart again
+	conf = keepRestartFields(r.current, conf)
+	r.env.Reconfigure(conf)
+	r.current = conf
+
+	for _, change := range changes {
+		if needsRestart(change.Field) {
+			slog.Warn("Config field is changed, it is applied after the restart",
+				"field", change.Field, "old", change.Old, "new", change.New)
+			continue
+		}
+		slog.Info("Config field is changed", "field", change.Field, "old", change.Old, "new", change.New)
+	}
+	slog.Info("Config is reloaded", "reason", reason, "path", r.path, "changes", len(changes))
+}
+
+// Config with the fields from restartFields taken from the running config
+func keepRestartFields(running config.Config, loaded config.Config) config.Config {
+	loaded.DataBase = running.DataBase
+	loaded.Server = running.Server
+	loaded.Tracing = running.Tracing
+	loaded.Scrapper.Proxies = running.Scrapper.Proxies
+	loaded.Scrapper.ProxyHealthUrl = running.Scrapper.ProxyHealthUrl
+	return loaded
+}
+
+func needsRestart(field string) bool {
+	for _, prefix := range restartFields {
+		if strings.HasPrefix(field, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,5 @@
 #!/bin/bash
 
-main=main.go
 app=server.app
 
 if [[ ! -d 'vendor' ]]; then
@@ -8,6 +7,6 @@ if [[ ! -d 'vendor' ]]; then
     dep ensure
 fi
 
-go build -o ${app} ${main}
+go build -o ${app} .
 
 md5sum ${app}
\ No newline at end of file
@@ -0,0 +1,11 @@
+#!/bin/bash
+
+# Generating Go code of the gRPC API from api/notification.proto.
+# Needs protoc, protoc-gen-go v1.36.4 and protoc-gen-go-grpc v1.5.1:
+#   go install google.golang.org/protobuf/cmd/protoc-gen-go@v1.36.4
+#   go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@v1.5.1
+
+protoc -I . \
+    --go_out=. --go_opt=module=test_avito \
+    --go-grpc_out=. --go-grpc_opt=module=test_avito \
+    api/notification.proto
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"test_avito/config"
+	"test_avito/src/logging"
+	"test_avito/utils"
+)
+
+// Upper bound of the workers set by the operator, every worker keeps a connection to avito
+const maxWorkers = 100
+
+// State of the scrapper of this instance shown to the operator
+type ScrapperState struct {
+	Running bool `json:"running"`
+	Paused  bool `json:"paused"`
+	Workers int  `json:"workers"`
+}
+
+// True if the Authorization header is "Bearer <admin token>". Without the token in the config nobody is the admin
+func (env *EnvironmentNotification) isAdminToken(header string) bool {
+	if env.AdminToken == "" || !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(env.AdminToken)) == 1
+}
+
+// Middleware that lets only requests with the admin token to the admin API.
+// Without the token in the config the admin API is disabled
+func (env *EnvironmentNotification) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if env.AdminToken == "" {
+			writeError(w, newApiError(http.StatusForbidden, ErrForbidden, "admin API is disabled", ""))
+			return
+		}
+
+		if !env.isAdminToken(r.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, newApiError(http.StatusUnauthorized, ErrUnauthorized, "admin token is not valid", ""))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func registerAdminRoutes(r *mux.Router, env *EnvironmentNotification) {
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(env.adminAuth)
+	admin.HandleFunc("/listings", env.AdminListingsHandler).Methods("GET")
+	admin.HandleFunc("/listings/recheck", env.AdminRecheckHandler).Methods("POST")
+	admin.HandleFunc("/scrapper", env.AdminScrapperHandler).Methods("GET")
+	admin.HandleFunc("/scrapper/pause", env.AdminPauseHandler(true)).Methods("POST")
+	admin.HandleFunc("/scrapper/resume", env.AdminPauseHandler(false)).Methods("POST")
+	admin.HandleFunc("/scrapper/workers", env.AdminWorkersHandler).Methods("POST")
+	admin.HandleFunc("/proxies", env.AdminProxiesHandler).Methods("GET")
+	admin.HandleFunc("/notifications/failed", env.AdminFailedNotificationsHandler).Methods("GET")
+	admin.HandleFunc("/notifications/failed/{id}/resend", env.AdminResendHandler).Methods("POST")
+}
+
+// Handler that returns the state of all urls in the queue. Can be filtered by the status of the last check
+func (env *EnvironmentNotification) AdminListingsHandler(w http.ResponseWriter, r *http.Request) {
+	statuses, err := env.Db.GetListingStatuses(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Listings were not loaded", "error", err)
+		writeError(w, newApiError(http.StatusInternalServerError, ErrInternal, "listings were not loaded", ""))
+		return
+	}
+
+	if filter := r.URL.Query().Get("status"); filter != "" {
+		filtered := make([]config.ListingStatus, 0, len(statuses))
+		for _, status := range statuses {
+			if status.Status == filter {
+				filtered = append(filtered, status)
+			}
+		}
+		statuses = filtered
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// Handler that makes the url due, so it is checked by the next free worker
+func (env *EnvironmentNotification) AdminRecheckHandler(w http.ResponseWriter, r *http.Request) {
+	url, _, err := utils.CanonicalUrl(r.URL.Query().Get("url"))
+	if err != nil {
+		writeError(w, newApiError(http.StatusBadRequest, ErrInvalidUrl, "url is not valid", "url"))
+		return
+	}
+
+	found, err := env.Db.RecheckNow(r.Context(), url)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Recheck was not scheduled", "url", url, "error", err)
+		writeError(w, newApiError(http.StatusInternalServerError, ErrInternal, "recheck was not scheduled", ""))
+		return
+	}
+	if !found {
+		writeError(w, newApiError(http.StatusNotFound, ErrListingNotFound, "url is not in the queue", "url"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (env *EnvironmentNotification) scrapperState() ScrapperState {
+	return ScrapperState{
+		Running: env.Scp.activity.alive(),
+		Paused:  env.Scp.Paused(),
+		Workers: env.Scp.Workers(),
+	}
+}
+
+// Handler that returns the state of the scrapper of this instance
+func (env *EnvironmentNotification) AdminScrapperHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, env.scrapperState())
+}
+
+// Handler that returns the statistics of the proxies of this instance
+func (env *EnvironmentNotification) AdminProxiesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, env.Scp.Proxies.Stats())
+}
+
+// Handler that pauses or resumes the scrapper of this instance
+func (env *EnvironmentNotification) AdminPauseHandler(pause bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if pause {
+			env.Scp.Pause()
+		} else {
+			env.Scp.Resume()
+		}
+		logging.FromContext(r.Context()).Info("Scrapper is paused by the operator", "paused", pause)
+		writeJSON(w, http.StatusOK, env.scrapperState())
+	}
+}
+
+// Handler that changes the number of workers of this instance. The number is passed
+// in the address bar or as a JSON body {"workers": 5}
+func (env *EnvironmentNotification) AdminWorkersHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Workers int `json:"workers"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := decodeBody(w, r, &req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if value := r.URL.Query().Get("workers"); value != "" && req.Workers == 0 {
+		req.Workers, _ = strconv.Atoi(value)
+	}
+	if req.Workers < 1 || req.Workers > maxWorkers {
+		writeError(w, newApiError(http.StatusBadRequest, ErrInvalidWorkers,
+			"workers must be from 1 to "+strconv.Itoa(maxWorkers), "workers"))
+		return
+	}
+
+	err := env.Scp.SetWorkers(req.Workers)
+	if err != nil {
+		writeError(w, newApiError(http.StatusServiceUnavailable, ErrTemporarilyUnavailable, "scrapper is not running", ""))
+		return
+	}
+	logging.FromContext(r.Context()).Info("Workers are changed by the operator", "workers", req.Workers)
+	writeJSON(w, http.StatusOK, env.scrapperState())
+}
+
+// Handler that returns notifications which were not delivered
+func (env *EnvironmentNotification) AdminFailedNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	notifications, err := env.Db.GetFailedNotifications(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed notifications were not loaded", "error", err)
+		writeError(w, newApiError(http.StatusInternalServerError, ErrInternal, "failed notifications were not loaded", ""))
+		return
+	}
+	writeJSON(w, http.StatusOK, notifications)
+}
+
+// Handler that sends the failed notification again
+func (env *EnvironmentNotification) AdminResendHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, newApiError(http.StatusNotFound, ErrNotificationNotFound, "notification is not found", "id"))
+		return
+	}
+
+	err = env.Db.ResendFailedNotification(r.Context(), id)
+	if err == sql.ErrNoRows {
+		writeError(w, newApiError(http.StatusNotFound, ErrNotificationNotFound, "notification is not found", "id"))
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Notification was not delivered again", "id", id, "error", err)
+		writeError(w, newApiError(http.StatusServiceUnavailable, ErrTemporarilyUnavailable, "notification was not delivered", ""))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
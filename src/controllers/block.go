@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"test_avito/config"
+	"test_avito/src/metrics"
+)
+
+var (
+	errBlocked       = errors.New("access is blocked by avito")
+	errHostCooldown  = errors.New("requests to avito are paused after a block")
+	errPriceNotFound = errors.New("price is not found on the page")
+)
+
+// Markers of the "access restricted" and captcha pages which avito serves with status 200
+var blockMarkers = []string{
+	"Доступ ограничен",
+	`class="firewall-title"`,
+	`data-marker="captcha"`,
+	`class="h-captcha"`,
+	"geetest_captcha",
+}
+
+// Pause of direct requests to the host after the first block, it is doubled after every next block
+var (
+	blockCooldown    = time.Minute
+	blockCooldownMax = 30 * time.Minute
+)
+
+// True if the page is a block or captcha page. Pages with the price or search results are never blocks
+func isBlockedPage(body string) bool {
+	if parsePrice(body, `"dynx_price":`, ",") != "" || isSearchPage(body) {
+		return false
+	}
+	for _, marker := range blockMarkers {
+		if strings.Contains(body, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+type cooldownState struct {
+	until  time.Time
+	blocks int
+}
+
+// Pauses of the hosts that have blocked direct requests of the crawler
+type hostCooldowns struct {
+	mu    sync.Mutex
+	hosts map[string]*cooldownState
+}
+
+func newHostCooldowns() *hostCooldowns {
+	return &hostCooldowns{hosts: make(map[string]*cooldownState)}
+}
+
+// Time left until requests to the host are allowed
+func (c *hostCooldowns) remaining(host string) time.Duration {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.hosts[host]
+	if !ok {
+		return 0
+	}
+	return time.Until(state.until)
+}
+
+// Starting the pause of the host, returns its duration
+func (c *hostCooldowns) block(host string) time.Duration {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.hosts[host]
+	if !ok {
+		state = &cooldownState{}
+		c.hosts[host] = state
+	}
+
+	pause := blockCooldown
+	for i := 0; i < state.blocks && pause < blockCooldownMax; i++ {
+		pause *= 2
+	}
+	if pause > blockCooldownMax {
+		pause = blockCooldownMax
+	}
+	state.blocks++
+	state.until = time.Now().Add(pause)
+	return pause
+}
+
+// The host answers again, next block starts with the shortest pause
+func (c *hostCooldowns) reset(host string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.hosts, host)
+}
+
+type userFetchKey struct{}
+
+// Context of the downloads which users start by their requests, for example the subscription
+func withUserFetch(ctx context.Context) context.Context {
+	return context.WithValue(ctx, userFetchKey{}, true)
+}
+
+func isUserFetch(ctx context.Context) bool {
+	user, _ := ctx.Value(userFetchKey{}).(bool)
+	return user
+}
+
+// Function that handles the block of the crawler. The proxy is evicted from the pool,
+// direct requests to the host are paused. Operators are alerted by the log and the event.
+// Anybody can send a url which answers 403, so the downloads of users neither pause the host
+// nor evict the proxy, otherwise they would stop the checks of all subscribers
+func (scp *Scrapper) reportBlock(ctx context.Context, url string, host string, proxy *proxyState) {
+	metrics.FetchBlocked.Inc()
+
+	if isUserFetch(ctx) {
+		slog.Warn("Download of the user is blocked", "url", url, "host", host)
+	} else if proxy != nil {
+		scp.Proxies.report(proxy, proxyBanned)
+		slog.Error("ALERT: avito blocks the crawler, the proxy is evicted", "alert", "blocked", "url", url, "proxy", proxy.url.Redacted())
+	} else {
+		pause := scp.cooldowns.block(host)
+		slog.Error("ALERT: avito blocks the crawler, requests to the host are paused", "alert", "blocked", "url", url, "host", host, "pause", pause.String())
+	}
+
+	scp.publishEvent(ctx, config.Event{Kind: config.EventScrapeBlocked, Url: url, Error: errBlocked.Error()}, nil)
+}
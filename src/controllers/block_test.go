@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+	"test_avito/src/metrics"
+)
+
+const blockedHTML = `<html><head><title>Доступ ограничен: проблема с IP</title></head>
+<body><h2 class="firewall-title">Доступ ограничен: проблема с IP</h2><div data-marker="captcha"></div></body></html>`
+
+func TestIsBlockedPage(t *testing.T) {
+	assert.True(t, isBlockedPage(blockedHTML))
+	assert.False(t, isBlockedPage(avitoHTML))
+	assert.False(t, isBlockedPage("<html><body>Новая разметка</body></html>"))
+}
+
+func TestBlockedPageIsNotParseError(t *testing.T) {
+	scp, _, _ := NewTestData()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, blockedHTML)
+	}))
+	defer server.Close()
+	scp.Client = server.Client()
+
+	blocked := testutil.ToFloat64(metrics.FetchBlocked)
+	priceChan := make(chan config.GetPriceResponse, 1)
+	scp.getPrice(context.Background(), server.URL, priceChan)
+	assert.Equal(t, errBlocked, (<-priceChan).Error)
+	assert.Equal(t, blocked+1, testutil.ToFloat64(metrics.FetchBlocked))
+}
+
+func TestPageWithoutPriceIsParseError(t *testing.T) {
+	scp, _, _ := NewTestData()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "<html><body>Новая разметка</body></html>")
+	}))
+	defer server.Close()
+	scp.Client = server.Client()
+
+	priceChan := make(chan config.GetPriceResponse, 1)
+	scp.getPrice(context.Background(), server.URL, priceChan)
+	assert.Equal(t, errPriceNotFound, (<-priceChan).Error)
+}
+
+func TestDirectBlockPausesRequests(t *testing.T) {
+	scp, _, _ := NewTestData()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	scp.Client = server.Client()
+
+	_, err := scp.fetchPage(context.Background(), server.URL)
+	assert.Equal(t, errBlocked, err)
+	_, err = scp.fetchPage(context.Background(), server.URL)
+	assert.Equal(t, errHostCooldown, err)
+	assert.Equal(t, 1, requests)
+	assert.True(t, scp.cooldowns.remaining(hostOf(server.URL)) > 0)
+}
+
+func TestUserFetchDoesNotPauseHost(t *testing.T) {
+	scp, _, _ := NewTestData()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	scp.Client = server.Client()
+
+	// The subscription to a blocked url does not stop the checks of the host
+	_, _, response := scp.resolveListing(context.Background(), server.URL)
+	assert.Equal(t, errBlocked, response.Error)
+	assert.Equal(t, time.Duration(0), scp.cooldowns.remaining(hostOf(server.URL)))
+
+	_, err := scp.fetchPage(context.Background(), server.URL)
+	assert.Equal(t, errBlocked, err)
+	assert.Equal(t, 2, requests)
+}
+
+func TestCooldownEscalates(t *testing.T) {
+	cooldowns := newHostCooldowns()
+	assert.Equal(t, blockCooldown, cooldowns.block("www.avito.ru"))
+	assert.Equal(t, 2*blockCooldown, cooldowns.block("www.avito.ru"))
+	assert.Equal(t, 4*blockCooldown, cooldowns.block("www.avito.ru"))
+	for i := 0; i < 10; i++ {
+		cooldowns.block("www.avito.ru")
+	}
+	assert.Equal(t, blockCooldownMax, cooldowns.block("www.avito.ru"))
+
+	cooldowns.reset("www.avito.ru")
+	assert.Equal(t, time.Duration(0), cooldowns.remaining("www.avito.ru"))
+	assert.Equal(t, blockCooldown, cooldowns.block("www.avito.ru"))
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"sync"
+
+	"test_avito/config"
+)
+
+// In-process publisher of events found by the scrapper.
+// Slow subscribers do not block the scrapper: events which do not fit into their buffer are dropped
+type Broker struct {
+	mu          sync.Mutex
+	lastId      uint64
+	subscribers map[chan config.Event]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[chan config.Event]struct{}),
+	}
+}
+
+// Creating a new subscription with the buffer of the specified size
+func (b *Broker) Subscribe(buffer int) chan config.Event {
+	ch := make(chan config.Event, buffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *Broker) Unsubscribe(ch chan config.Event) {
+	b.mu.Lock()
+	delete(b.subscribers, ch)
+	b.mu.Unlock()
+}
+
+// True if somebody listens to the events, so that the scrapper does not prepare them in vain
+func (b *Broker) HasSubscribers() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers) > 0
+}
+
+// Sending the event to all subscribers. Events are numbered in the order of publishing
+func (b *Broker) Publish(event config.Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastId++
+	event.Id = b.lastId
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// Defaults of the canary if they are not set in the config
+const (
+	defaultCanaryWindow    = 100
+	defaultCanaryThreshold = 0.5
+)
+
+// Results of the last parsed pages of one host
+type hostParses struct {
+	results   []bool
+	next      int
+	count     int
+	successes int
+	degraded  bool
+}
+
+// Canary that watches the part of successfully parsed pages of every host. When avito changes
+// its markup, prices are not found on all pages, so the part drops and the operator is alerted
+type parseCanary struct {
+	mu        sync.Mutex
+	window    int
+	threshold float64
+	hosts     map[string]*hostParses
+	// Called without the lock when the host becomes degraded or recovers
+	alert func(host string, ratio float64, degraded bool)
+}
+
+// State of the parser for the host served on /healthz
+type HostParseStatus struct {
+	Host         string  `json:"host"`
+	Checks       int     `json:"checks"`
+	SuccessRatio float64 `json:"success_ratio"`
+	Degraded     bool    `json:"degraded"`
+}
+
+func newParseCanary(window int, threshold float64) *parseCanary {
+	c := &parseCanary{hosts: make(map[string]*hostParses)}
+	c.configure(window, threshold)
+	return c
+}
+
+// Function that changes the window and the threshold. Results of the hosts are forgotten
+// if the window is changed, they do not fit the new window
+func (c *parseCanary) configure(window int, threshold float64) {
+	if c == nil {
+		return
+	}
+	if window <= 0 {
+		window = defaultCanaryWindow
+	}
+	if threshold <= 0 {
+		threshold = defaultCanaryThreshold
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if window != c.window {
+		c.hosts = make(map[string]*hostParses)
+	}
+	c.window = window
+	c.threshold = threshold
+}
+
+// Function that remembers the result of parsing the page. The host is judged only after
+// the whole window is filled, so a few broken ads after the start do not raise the alert
+func (c *parseCanary) record(host string, parsed bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	state, ok := c.hosts[host]
+	if !ok {
+		state = &hostParses{results: make([]bool, c.window)}
+		c.hosts[host] = state
+	}
+
+	if state.count == len(state.results) {
+		if state.results[state.next] {
+			state.successes--
+		}
+	} else {
+		state.count++
+	}
+	state.results[state.next] = parsed
+	state.next = (state.next + 1) % len(state.results)
+	if parsed {
+		state.successes++
+	}
+
+	ratio := float64(state.successes) / float64(state.count)
+	changed := false
+	if state.count == len(state.results) && !state.degraded && ratio < c.threshold {
+		state.degraded = true
+		changed = true
+	} else if state.degraded && ratio >= c.threshold {
+		state.degraded = false
+		changed = true
+	}
+	degraded := state.degraded
+	alert := c.alert
+	c.mu.Unlock()
+
+	if changed && alert != nil {
+		alert(host, ratio, degraded)
+	}
+}
+
+// Parser states of all hosts sorted by the host
+func (c *parseCanary) Status() []HostParseStatus {
+	statuses := []HostParseStatus{}
+	if c == nil {
+		return statuses
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for host, state := range c.hosts {
+		statuses = append(statuses, HostParseStatus{
+			Host:         host,
+			Checks:       state.count,
+			SuccessRatio: float64(state.successes) / float64(state.count),
+			Degraded:     state.degraded,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Host < statuses[j].Host })
+	return statuses
+}
+
+// Function that alerts the operator about the change of the markup by the log and the email
+func (scp *Scrapper) alertParserDrift(host string, ratio float64, degraded bool) {
+	text := fmt.Sprintf("Parser of %s has recovered, %.0f%% of the last pages are parsed", host, ratio*100)
+	if degraded {
+		text = fmt.Sprintf("Parser of %s is degraded, only %.0f%% of the last pages are parsed. "+
+			"Probably avito has changed its markup", host, ratio*100)
+	}
+	if degraded {
+		slog.Error("ALERT: "+text, "alert", "parser_drift", "host", host, "success_ratio", ratio)
+	} else {
+		slog.Info(text, "host", host, "success_ratio", ratio)
+	}
+
+	if email := scp.opts().operatorEmail; email != "" && scp.Db != nil {
+		go scp.Db.SendOperatorAlert(context.Background(), email, text)
+	}
+}
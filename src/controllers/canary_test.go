@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+)
+
+type driftAlert struct {
+	host     string
+	degraded bool
+}
+
+func newTestCanary(window int, threshold float64) (*parseCanary, *[]driftAlert) {
+	alerts := &[]driftAlert{}
+	canary := newParseCanary(window, threshold)
+	canary.alert = func(host string, ratio float64, degraded bool) {
+		*alerts = append(*alerts, driftAlert{host, degraded})
+	}
+	return canary, alerts
+}
+
+func TestCanaryWaitsForFullWindow(t *testing.T) {
+	canary, alerts := newTestCanary(4, 0.5)
+	for i := 0; i < 3; i++ {
+		canary.record("www.avito.ru", false)
+	}
+	assert.Empty(t, *alerts)
+
+	canary.record("www.avito.ru", false)
+	assert.Equal(t, []driftAlert{{"www.avito.ru", true}}, *alerts)
+
+	// The alert is raised once per degradation
+	canary.record("www.avito.ru", false)
+	assert.Len(t, *alerts, 1)
+}
+
+func TestCanaryRecovers(t *testing.T) {
+	canary, alerts := newTestCanary(4, 0.5)
+	for _, parsed := range []bool{true, false, false, false, true, true} {
+		canary.record("www.avito.ru", parsed)
+	}
+	assert.Equal(t, []driftAlert{{"www.avito.ru", true}, {"www.avito.ru", false}}, *alerts)
+
+	status := canary.Status()
+	assert.Len(t, status, 1)
+	assert.Equal(t, HostParseStatus{Host: "www.avito.ru", Checks: 4, SuccessRatio: 0.5, Degraded: false}, status[0])
+}
+
+func TestCanaryCountsHostsSeparately(t *testing.T) {
+	canary, alerts := newTestCanary(2, 0.5)
+	canary.record("www.avito.ru", false)
+	canary.record("m.avito.ru", true)
+	canary.record("www.avito.ru", false)
+	canary.record("m.avito.ru", true)
+	assert.Equal(t, []driftAlert{{"www.avito.ru", true}}, *alerts)
+}
+
+func TestHealthShowsDegradedHost(t *testing.T) {
+	scp, _, _ := NewTestData()
+	scp.canary, _ = newTestCanary(2, 0.5)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "<html><body>Новая разметка</body></html>")
+	}))
+	defer server.Close()
+	scp.Client = server.Client()
+
+	for i := 0; i < 2; i++ {
+		priceChan := make(chan config.GetPriceResponse, 1)
+		scp.getPrice(context.Background(), server.URL, priceChan)
+		<-priceChan
+	}
+
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	w := httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var health HealthResponse
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&health))
+	assert.Equal(t, "degraded", health.Status)
+	assert.Len(t, health.Hosts, 1)
+	assert.True(t, health.Hosts[0].Degraded)
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"syscall"
+	"time"
+
+	"test_avito/utils"
+)
+
+// Urls of the users are fetched by the scrapper, so they must not lead to the service's own network:
+// localhost, private networks or the metadata of the cloud
+var (
+	errForbiddenTarget  = errors.New("url is not allowed to be fetched")
+	errTooManyRedirects = errors.New("too many redirects")
+)
+
+// Sites are fetched only on the standard ports
+var allowedPorts = map[string]bool{"": true, "80": true, "443": true}
+
+// Redirects after which the page is not fetched
+const maxRedirects = 5
+
+// Function that checks the scheme, the port and the address of the url if it is written as IP
+func checkTarget(u *neturl.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q", errForbiddenTarget, u.Scheme)
+	}
+	if !allowedPorts[u.Port()] {
+		return fmt.Errorf("%w: port %s", errForbiddenTarget, u.Port())
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !utils.IsPublicIp(ip) {
+		return fmt.Errorf("%w: address %s", errForbiddenTarget, ip)
+	}
+	return nil
+}
+
+// Function for net.Dialer that is called after the name is resolved, so the real address is checked
+// and the name can not be changed to a private address between the check and the connection
+func checkDialedAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: address %s", errForbiddenTarget, address)
+	}
+	if ip := net.ParseIP(host); ip == nil || !utils.IsPublicIp(ip) {
+		return fmt.Errorf("%w: address %s", errForbiddenTarget, host)
+	}
+	return nil
+}
+
+// Function that creates the dial function of the transport. Proxies of the config are trusted,
+// they can be in the private network, all other connections go only to public addresses
+func safeDialContext() func(ctx context.Context, network string, address string) (net.Conn, error) {
+	direct := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: checkDialedAddress}
+	proxied := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if proxy, _ := ctx.Value(proxyContextKey{}).(*proxyState); proxy != nil {
+			return proxied.DialContext(ctx, network, address)
+		}
+		return direct.DialContext(ctx, network, address)
+	}
+}
+
+// Transport that checks every request, also every redirect, before it is sent
+type safeTransport struct {
+	base     http.RoundTripper
+	resolver *net.Resolver
+}
+
+func (t safeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := checkTarget(req.URL)
+	if err == nil {
+		err = t.checkProxied(req)
+	}
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// The proxy resolves the name itself, so the addresses are checked before the request is sent to it
+func (t safeTransport) checkProxied(req *http.Request) error {
+	if proxy, _ := req.Context().Value(proxyContextKey{}).(*proxyState); proxy == nil {
+		return nil
+	}
+	host := req.URL.Hostname()
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	addrs, err := t.resolver.LookupIPAddr(req.Context(), host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !utils.IsPublicIp(addr.IP) {
+			return fmt.Errorf("%w: %s is resolved to %s", errForbiddenTarget, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// Function for http.Client that limits the number of redirects.
+// Every next url is checked by the transport like the first one
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("%w: more than %d", errTooManyRedirects, maxRedirects)
+	}
+	return checkTarget(req.URL)
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestCheckTarget(t *testing.T) {
+	for _, raw := range []string{"https://www.avito.ru/1791027290", "http://avito.ru:80/", "https://8.8.8.8:443/"} {
+		u, _ := neturl.Parse(raw)
+		assert.Nil(t, checkTarget(u), raw)
+	}
+	for _, raw := range []string{"ftp://avito.ru/", "file:///etc/passwd", "gopher://avito.ru/", "https://avito.ru:22/",
+		"http://127.0.0.1/", "http://169.254.169.254/latest/meta-data/", "http://[::1]/"} {
+		u, _ := neturl.Parse(raw)
+		assert.True(t, errors.Is(checkTarget(u), errForbiddenTarget), raw)
+	}
+}
+
+func TestScrapperDoesNotConnectToLocalAddresses(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("local server must not be requested")
+	}))
+	defer local.Close()
+
+	// The port is allowed, so the address is rejected by the dialer after the name is resolved
+	_, port, _ := net.SplitHostPort(local.Listener.Addr().String())
+	allowedPorts[port] = true
+	defer delete(allowedPorts, port)
+
+	scp := NewScrapper(nil, config.Default())
+	_, err := scp.Client.Get("http://localhost:" + port + "/")
+	assert.True(t, errors.Is(err, errForbiddenTarget), err)
+}
+
+func TestRedirectIsChecked(t *testing.T) {
+	requests := 0
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return &http.Response{
+			StatusCode: http.StatusFound,
+			Header:     http.Header{"Location": {"http://169.254.169.254/latest/meta-data/"}},
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	})
+	client := &http.Client{Transport: safeTransport{base: base, resolver: net.DefaultResolver}, CheckRedirect: checkRedirect}
+
+	_, err := client.Get("https://www.avito.ru/1791027290")
+	assert.True(t, errors.Is(err, errForbiddenTarget), err)
+	assert.Equal(t, 1, requests)
+
+	// Redirects to allowed urls are followed a limited number of times
+	base = func(req *http.Request) (*http.Response, error) {
+		requests++
+		return &http.Response{
+			StatusCode: http.StatusFound,
+			Header:     http.Header{"Location": {"https://www.avito.ru/next"}},
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+	requests = 0
+	client.Transport = safeTransport{base: base, resolver: net.DefaultResolver}
+	_, err = client.Get("https://www.avito.ru/1791027290")
+	assert.True(t, errors.Is(err, errTooManyRedirects), err)
+	assert.Equal(t, maxRedirects, requests)
+}
+
+func TestProxiedRequestIsResolvedBeforeSending(t *testing.T) {
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Error("request must not be sent to the proxy")
+		return nil, errors.New("sent")
+	})
+	transport := safeTransport{base: base, resolver: net.DefaultResolver}
+
+	proxy := &proxyState{url: &neturl.URL{Scheme: "http", Host: "10.0.0.2:3128"}}
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), proxyContextKey{}, proxy))
+	_, err := transport.RoundTrip(req)
+	assert.True(t, errors.Is(err, errForbiddenTarget), err)
+	assert.Equal(t, proxyNotUsed, resultOf(nil, err))
+}
+
+func TestSubscribeRejectsMetadataAddress(t *testing.T) {
+	testData, _, _ := NewTestData()
+	env := EnvironmentNotification{Db: testData.Db, Scp: NewScrapper(nil, config.Default())}
+
+	w := subscribeRequest(&env, "http://169.254.169.254/latest/meta-data/", "d_kokin@inbox.ru")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), ErrHostNotAllowed)
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"test_avito/config"
+	"test_avito/utils"
+)
+
+// Size of the buffer of events for one client. Events are dropped for clients that read slower
+const eventsBufferSize = 64
+
+// Pause between comments sent to keep idle connections open through proxies
+var heartbeatInterval = 15 * time.Second
+
+// True if the event is about the url and one of its subscribers is the email. Empty filters match everything
+func matchEvent(event config.Event, url string, email string) bool {
+	if url != "" && event.Url != url {
+		return false
+	}
+	if email == "" {
+		return true
+	}
+	for _, value := range event.Emails {
+		if value == email {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler that streams events of the scrapper as Server-Sent Events.
+// Events can be filtered by url and by the feed token of the user in the address bar, all events
+// are streamed only with the admin token
+func (env *EnvironmentNotification) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, newApiError(http.StatusInternalServerError, ErrInternal, "streaming is not supported", ""))
+		return
+	}
+
+	query := r.URL.Query()
+	url := query.Get("url")
+	if url != "" {
+		var err error
+		url, _, err = utils.CanonicalUrl(url)
+		if err != nil {
+			writeError(w, newApiError(http.StatusBadRequest, ErrInvalidUrl, "url is not valid", "url"))
+			return
+		}
+	}
+	// Events of one user are given only by the token of the user's feed, the email alone is not trusted
+	if query.Get("email") != "" {
+		writeError(w, newApiError(http.StatusUnauthorized, ErrUnauthorized, "feed token is required to filter by user", "token"))
+		return
+	}
+	var email string
+	if token := query.Get("token"); token != "" {
+		var err error
+		email, err = env.emailByToken(r.Context(), token)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	// The stream without filters shows urls and prices of all subscribers, it is given only to the admin
+	if url == "" && email == "" && !env.isAdminToken(r.Header.Get("Authorization")) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeError(w, newApiError(http.StatusUnauthorized, ErrUnauthorized, "url, feed token or admin token is required", "token"))
+		return
+	}
+
+	broker := env.Scp.Events
+	if broker == nil {
+		writeError(w, newApiError(http.StatusServiceUnavailable, ErrInternal, "scrapper does not publish events", ""))
+		return
+	}
+	events := broker.Subscribe(eventsBufferSize)
+	defer broker.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event := <-events:
+			if !matchEvent(event, url, email) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Kind, data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
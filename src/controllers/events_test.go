@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"bufio"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+	"test_avito/src/metrics"
+)
+
+// Reading lines of one event of the stream, comments are returned as is
+func readEvent(t *testing.T, reader *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		line = strings.TrimRight(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestEventsStreamFilteredByToken(t *testing.T) {
+	scp, _, mock := NewTestData()
+	scp.Events = NewBroker()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	server := httptest.NewServer(NewRouter(&env))
+	defer server.Close()
+
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WithArgs("feed-token").
+		WillReturnRows(mock.NewRows([]string{"email"}).AddRow("d_kokin@inbox.ru"))
+
+	resp, err := http.Get(server.URL + "/api/v1/events?token=feed-token")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	for !scp.Events.HasSubscribers() {
+		time.Sleep(time.Millisecond)
+	}
+	scp.Events.Publish(config.Event{Kind: config.EventPriceChange, Url: "other", Emails: []string{"other@inbox.ru"}})
+	scp.Events.Publish(config.Event{Kind: config.EventListingRemoved, Url: "mine", Emails: []string{"d_kokin@inbox.ru"}})
+
+	lines := readEvent(t, bufio.NewReader(resp.Body))
+	assert.Equal(t, []string{"id: 2", "event: listing_removed"}, lines[:2])
+	assert.Contains(t, lines[2], `"url":"mine"`)
+}
+
+func TestEventsHeartbeat(t *testing.T) {
+	heartbeatInterval = 10 * time.Millisecond
+	defer func() { heartbeatInterval = 15 * time.Second }()
+
+	scp, _, _ := NewTestData()
+	scp.Events = NewBroker()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp, AdminToken: testAdminToken}
+	server := httptest.NewServer(NewRouter(&env))
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+"/events", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, []string{": ping"}, readEvent(t, bufio.NewReader(resp.Body)))
+}
+
+func TestAllEventsRequireAdminToken(t *testing.T) {
+	scp, _, _ := NewTestData()
+	scp.Events = NewBroker()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp, AdminToken: testAdminToken}
+
+	// Urls and prices of all subscribers are not given to anyone
+	w := httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/events", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req := httptest.NewRequest("GET", "/api/v1/events", nil)
+	req.Header.Set("Authorization", "Bearer wrong-token")
+	w = httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, scp.Events.HasSubscribers())
+}
+
+func TestEventsInvalidFilter(t *testing.T) {
+	scp, _, _ := NewTestData()
+	scp.Events = NewBroker()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+
+	req, err := http.NewRequest("GET", "http://localhost/events?url=badurl", nil)
+	assert.Nil(t, err)
+	w := httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.False(t, scp.Events.HasSubscribers())
+}
+
+func TestEventsOfUserRequireToken(t *testing.T) {
+	scp, _, mock := NewTestData()
+	scp.Events = NewBroker()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+
+	// Anyone knows the email, so it does not give the events of the user
+	w := httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, httptest.NewRequest("GET", "/events?email=d_kokin@inbox.ru", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WithArgs("unknown").
+		WillReturnError(sql.ErrNoRows)
+	w = httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, httptest.NewRequest("GET", "/events?token=unknown", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, scp.Events.HasSubscribers())
+}
+
+func TestBrokerDropsEventsOfSlowSubscribers(t *testing.T) {
+	broker := NewBroker()
+	events := broker.Subscribe(1)
+
+	broker.Publish(config.Event{Url: "first"})
+	broker.Publish(config.Event{Url: "second"})
+
+	assert.Equal(t, "first", (<-events).Url)
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %v", event)
+	default:
+	}
+}
+
+func TestWorkerPublishesListingRemoved(t *testing.T) {
+	scp, _, sqlMock := NewTestData()
+	scp.Events = NewBroker()
+	events := scp.Events.Subscribe(4)
+
+	removedServer := httptest.NewServer(http.NotFoundHandler())
+	defer removedServer.Close()
+	scp.Client = removedServer.Client()
+
+	sqlMock.ExpectQuery("SELECT acc_verified, email, price, url FROM subscription").
+		WithArgs(removedServer.URL).
+		WillReturnRows(sqlmock.NewRows([]string{"acc_verified", "email", "price", "url"}).
+			AddRow(true, "d_kokin@inbox.ru", 100, removedServer.URL))
+
+	published := testutil.ToFloat64(metrics.EventsPublished.WithLabelValues(config.EventListingRemoved))
+	sentEvents := testutil.ToFloat64(metrics.Notifications.WithLabelValues("event", metrics.NotificationSent))
+	scp.checkPair(config.CheckPriceRequest{OldPrice: 100, Url: removedServer.URL})
+
+	event := <-events
+	assert.Equal(t, config.EventListingRemoved, event.Kind)
+	assert.Equal(t, []string{"d_kokin@inbox.ru"}, event.Emails)
+	// Events are not notifications
+	assert.Equal(t, published+1, testutil.ToFloat64(metrics.EventsPublished.WithLabelValues(config.EventListingRemoved)))
+	assert.Equal(t, sentEvents, testutil.ToFloat64(metrics.Notifications.WithLabelValues("event", metrics.NotificationSent)))
+}
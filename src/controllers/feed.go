@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"test_avito/config"
+)
+
+// Number of the latest price changes shown in the feed
+const feedSize = 50
+
+func feedPath(token string, format string) string {
+	return fmt.Sprintf("%s/feed/%s.%s", apiPrefix, token, format)
+}
+
+// Id of the entry depends only on the change, so readers do not show it twice
+func feedEntryId(change config.PriceChangeRecord) string {
+	sum := sha1.Sum([]byte(change.Url + "|" + change.ChangedAt.UTC().Format(time.RFC3339Nano)))
+	return "urn:sha1:" + hex.EncodeToString(sum[:])
+}
+
+func feedEntryTitle(change config.PriceChangeRecord) string {
+	return fmt.Sprintf("Price changed from %d to %d", change.OldPrice, change.NewPrice)
+}
+
+// Time of the latest change, the feed is never updated if there are no changes
+func feedUpdated(changes []config.PriceChangeRecord) time.Time {
+	if len(changes) == 0 {
+		return time.Unix(0, 0).UTC()
+	}
+	return changes[0].ChangedAt.UTC()
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Id      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	Guid        struct {
+		IsPermaLink string `xml:"isPermaLink,attr"`
+		Value       string `xml:",chardata"`
+	} `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+}
+
+type rssFeed struct {
+	XMLName       xml.Name  `xml:"rss"`
+	Version       string    `xml:"version,attr"`
+	Title         string    `xml:"channel>title"`
+	Link          string    `xml:"channel>link"`
+	Description   string    `xml:"channel>description"`
+	LastBuildDate string    `xml:"channel>lastBuildDate"`
+	Items         []rssItem `xml:"channel>item"`
+}
+
+func buildAtomFeed(token string, self string, changes []config.PriceChangeRecord) atomFeed {
+	sum := sha1.Sum([]byte(token))
+	feed := atomFeed{
+		Id:      "urn:sha1:" + hex.EncodeToString(sum[:]),
+		Title:   "Price changes of your subscriptions",
+		Updated: feedUpdated(changes).Format(time.RFC3339),
+		Author:  "Avito price notification service",
+		Link:    atomLink{Href: self, Rel: "self"},
+	}
+	for _, change := range changes {
+		feed.Entries = append(feed.Entries, atomEntry{
+			Id:      feedEntryId(change),
+			Title:   feedEntryTitle(change),
+			Updated: change.ChangedAt.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: change.Url, Rel: "alternate"},
+			Summary: fmt.Sprintf("%s: %d -> %d", change.Url, change.OldPrice, change.NewPrice),
+		})
+	}
+	return feed
+}
+
+func buildRssFeed(self string, changes []config.PriceChangeRecord) rssFeed {
+	feed := rssFeed{
+		Version:       "2.0",
+		Title:         "Price changes of your subscriptions",
+		Link:          self,
+		Description:   "Latest price changes of the ads you are subscribed to",
+		LastBuildDate: feedUpdated(changes).Format(time.RFC1123Z),
+	}
+	for _, change := range changes {
+		item := rssItem{
+			Title:       feedEntryTitle(change),
+			Link:        change.Url,
+			Description: fmt.Sprintf("%s: %d -> %d", change.Url, change.OldPrice, change.NewPrice),
+			PubDate:     change.ChangedAt.UTC().Format(time.RFC1123Z),
+		}
+		item.Guid.IsPermaLink = "false"
+		item.Guid.Value = feedEntryId(change)
+		feed.Items = append(feed.Items, item)
+	}
+	return feed
+}
+
+// Handler that returns the feed of price changes of all user's subscriptions in Atom or RSS format
+func (env *EnvironmentNotification) FeedHandler(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := mux.Vars(r)["token"]
+		changes, err := env.Feed(r.Context(), token)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		self := scheme + "://" + r.Host + r.URL.Path
+
+		var feed interface{}
+		contentType := "application/atom+xml; charset=utf-8"
+		if format == "rss" {
+			feed = buildRssFeed(self, changes)
+			contentType = "application/rss+xml; charset=utf-8"
+		} else {
+			feed = buildAtomFeed(token, self, changes)
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Last-Modified", feedUpdated(changes).Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(xml.Header))
+		_ = xml.NewEncoder(w).Encode(feed)
+	}
+}
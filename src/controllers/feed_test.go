@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const feedToken = "0123456789abcdef0123456789abcdef"
+
+func expectFeed(mock sqlmock.Sqlmock, changedAt time.Time) {
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WithArgs(feedToken).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("d_kokin@inbox.ru"))
+	mock.ExpectQuery("SELECT url, old_price, price, checked_at FROM").
+		WithArgs("d_kokin@inbox.ru", feedSize).
+		WillReturnRows(sqlmock.NewRows([]string{"url", "old_price", "price", "checked_at"}).
+			AddRow("https://www.avito.ru/moskva/bmw_1791027290", 100, 90, changedAt).
+			AddRow("https://www.avito.ru/moskva/audi_1791027291", 200, 210, changedAt.Add(-time.Hour)))
+}
+
+func TestAtomFeed(t *testing.T) {
+	scp, _, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	changedAt := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	expectFeed(mock, changedAt)
+
+	req, err := http.NewRequest("GET", "http://localhost/api/v1/feed/"+feedToken+".atom", nil)
+	assert.Nil(t, err)
+	w := httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/atom+xml; charset=utf-8", w.Header().Get("Content-Type"))
+
+	var feed atomFeed
+	assert.Nil(t, xml.NewDecoder(w.Body).Decode(&feed))
+	assert.Equal(t, "2020-10-01T12:00:00Z", feed.Updated)
+	assert.Len(t, feed.Entries, 2)
+	assert.Equal(t, "Price changed from 100 to 90", feed.Entries[0].Title)
+	assert.Equal(t, "https://www.avito.ru/moskva/bmw_1791027290", feed.Entries[0].Link.Href)
+	assert.NotEqual(t, feed.Entries[0].Id, feed.Entries[1].Id)
+}
+
+func TestRssFeedHasStableIds(t *testing.T) {
+	scp, _, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	changedAt := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	expectFeed(mock, changedAt)
+	expectFeed(mock, changedAt)
+
+	var guids [2]string
+	for i := range guids {
+		req, err := http.NewRequest("GET", "http://localhost/feed/"+feedToken+".rss", nil)
+		assert.Nil(t, err)
+		w := httptest.NewRecorder()
+		NewRouter(&env).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var feed rssFeed
+		assert.Nil(t, xml.NewDecoder(w.Body).Decode(&feed))
+		assert.Equal(t, "Thu, 01 Oct 2020 12:00:00 +0000", feed.LastBuildDate)
+		assert.Len(t, feed.Items, 2)
+		assert.Equal(t, "false", feed.Items[0].Guid.IsPermaLink)
+		guids[i] = feed.Items[0].Guid.Value
+	}
+	assert.Equal(t, guids[0], guids[1])
+}
+
+func TestFeedUnknownToken(t *testing.T) {
+	scp, _, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WithArgs("unknown").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}))
+
+	req, err := http.NewRequest("GET", "http://localhost/feed/unknown.atom", nil)
+	assert.Nil(t, err)
+	w := httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ErrFeedNotFound, decodeApiError(t, w).Code)
+}
+
+func TestConfirmReturnsFeedLinks(t *testing.T) {
+	scp, _, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+
+	mock.ExpectQuery("SELECT \\* FROM auth_confirmation").
+		WithArgs("hash").
+		WillReturnRows(mock.NewRows([]string{"email", "hash", "deadline"}).
+			AddRow("d_kokin@inbox.ru", "hash", time.Now().Add(time.Hour)))
+	mock.ExpectExec("UPDATE subscription").
+		WithArgs("d_kokin@inbox.ru").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM auth_confirmation").
+		WithArgs("hash").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO feed_token").
+		WithArgs("d_kokin@inbox.ru", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT token FROM feed_token").
+		WithArgs("d_kokin@inbox.ru").
+		WillReturnRows(sqlmock.NewRows([]string{"token"}).AddRow(feedToken))
+
+	req, err := http.NewRequest("GET", "http://localhost/confirm?hash=hash", nil)
+	assert.Nil(t, err)
+	w := httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]string
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "/api/v1/feed/"+feedToken+".atom", response["feed_atom"])
+	assert.Equal(t, "/api/v1/feed/"+feedToken+".rss", response["feed_rss"])
+}
@@ -0,0 +1,329 @@
+package controllers
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"go.opentelemetry.io/otel/attribute"
+
+	"test_avito/config"
+	"test_avito/src/metrics"
+	"test_avito/src/tracing"
+)
+
+// Default limit of the downloaded page after decoding, pages of avito are about 1 MiB
+const defaultMaxPageSize = 4 << 20
+
+// Size of the piece of the page read at once, the markers of the extraction are looked for in every piece
+const fetchChunkSize = 32 << 10
+
+// Number of pages whose validators are remembered by the scrapper
+const fetchCacheSize = 20000
+
+var errPageTooLarge = errors.New("page is too large")
+
+// Decoders of the Content-Encoding of the pages, all of them are sent in Accept-Encoding
+var contentDecoders = map[string]func(io.Reader) (io.Reader, error){
+	"br": func(r io.Reader) (io.Reader, error) {
+		return brotli.NewReader(r), nil
+	},
+	"gzip": func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	"deflate": func(r io.Reader) (io.Reader, error) {
+		return flate.NewReader(r), nil
+	},
+}
+
+func acceptEncoding() string {
+	encodings := make([]string, 0, len(contentDecoders))
+	for name := range contentDecoders {
+		encodings = append(encodings, name)
+	}
+	sort.Strings(encodings)
+	return strings.Join(encodings, ", ")
+}
+
+// Page of the previous download that is used if the site answers 304 Not Modified
+type cachedPage struct {
+	etag         string
+	lastModified string
+	size         int64
+	response     config.GetPriceResponse
+}
+
+type fetchCache struct {
+	mu    sync.Mutex
+	pages map[string]cachedPage
+}
+
+func newFetchCache() *fetchCache {
+	return &fetchCache{pages: make(map[string]cachedPage)}
+}
+
+func (cache *fetchCache) get(url string) (cachedPage, bool) {
+	if cache == nil {
+		return cachedPage{}, false
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	page, ok := cache.pages[url]
+	return page, ok
+}
+
+func (cache *fetchCache) put(url string, page cachedPage) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if _, ok := cache.pages[url]; !ok && len(cache.pages) >= fetchCacheSize {
+		// Any page is forgotten, it is only downloaded once more without validators
+		for key := range cache.pages {
+			delete(cache.pages, key)
+			break
+		}
+	}
+	cache.pages[url] = page
+}
+
+// Downloaded page. If the page is not modified, cached is the result of the previous download
+type fetchedPage struct {
+	body         string
+	url          *neturl.URL
+	size         int64
+	etag         string
+	lastModified string
+	cached       *config.GetPriceResponse
+}
+
+// True if the price and the whole description of the ad are already in the beginning of the page
+func extractionComplete(body string) bool {
+	if parsePrice(body, `"dynx_price":`, ",") == "" {
+		return false
+	}
+	info := parseListingInfo(body)
+	return info.Title != "" && info.ImageUrl != "" && info.Location != "" && info.Seller != "" && info.PublishedAt != nil
+}
+
+// Markers which have to be on the page before the extraction is tried, the title has two of them
+var extractionMarkers = [][]string{
+	{`"dynx_price":`}, {titleMarker, ogTitleMarker}, {ogImageMarker}, {locationMarker}, {sellerMarker}, {publishedAtMarker},
+}
+
+// Times the whole page is parsed after all markers have appeared, their values may come in the next pieces
+const maxExtractionAttempts = 3
+
+// Markers found in the downloaded part of the page. Every piece is scanned once, so the page
+// is not parsed again from the beginning after every piece
+type extractionTracker struct {
+	found    []bool
+	scanned  int
+	attempts int
+}
+
+func newExtractionTracker() *extractionTracker {
+	return &extractionTracker{found: make([]bool, len(extractionMarkers))}
+}
+
+// True if the price and the whole description of the ad are in the downloaded part of the page
+func (t *extractionTracker) complete(body string) bool {
+	if t.attempts >= maxExtractionAttempts {
+		return false
+	}
+
+	// The marker can be split between two pieces, so the end of the previous one is scanned again
+	from := 0
+	for _, markers := range extractionMarkers {
+		for _, marker := range markers {
+			if start := t.scanned - len(marker) + 1; start > 0 && (from == 0 || start < from) {
+				from = start
+			}
+		}
+	}
+	fresh := body[from:]
+	t.scanned = len(body)
+
+	all := true
+	for i, markers := range extractionMarkers {
+		for _, marker := range markers {
+			if !t.found[i] && strings.Contains(fresh, marker) {
+				t.found[i] = true
+			}
+		}
+		all = all && t.found[i]
+	}
+	if !all {
+		return false
+	}
+	t.attempts++
+	return extractionComplete(body)
+}
+
+// Reader that counts bytes received from the network
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// Function that downloads the page with conditional request and compression.
+// The page is read piece by piece until the price and the description are found
+func (scp *Scrapper) fetchPage(ctx context.Context, url string) (page fetchedPage, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return page, err
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding())
+
+	cached, hasCached := scp.cache.get(url)
+	if hasCached {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	host := req.URL.Host
+	if scp.cooldowns.remaining(host) > 0 {
+		return page, errHostCooldown
+	}
+
+	// The trace context is not sent to avito, the span only measures the download
+	_, span := tracing.Start(ctx, "fetchPage", attribute.String("server.address", host))
+	started := time.Now()
+	defer func() {
+		metrics.FetchDuration.WithLabelValues(host).Observe(time.Since(started).Seconds())
+		span.SetAttributes(attribute.Int64("fetch.page_size", page.size), attribute.Bool("fetch.not_modified", page.cached != nil))
+		tracing.End(span, err)
+	}()
+
+	resp, proxy, err := scp.doRequest(req)
+	if proxy != nil {
+		span.SetAttributes(attribute.String("fetch.proxy", proxy.url.Redacted()))
+	}
+	if err != nil {
+		// The download stopped by the caller is not a failure of the proxy
+		if ctx.Err() == nil {
+			scp.Proxies.report(proxy, proxyFailed)
+		}
+		return page, err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	// Url after the redirects
+	page.url = resp.Request.URL
+
+	if resultOf(resp, nil) == proxyBanned {
+		scp.reportBlock(ctx, url, host, proxy)
+		return page, errBlocked
+	}
+	scp.Proxies.report(proxy, resultOf(resp, nil))
+
+	if resp.StatusCode == http.StatusNotModified && hasCached {
+		metrics.FetchNotModified.Inc()
+		metrics.FetchBytesSaved.Add(float64(cached.size))
+		page.cached = &cached.response
+		scp.cooldowns.reset(host)
+		return page, nil
+	}
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return page, errListingRemoved
+	}
+
+	if resp.StatusCode != 200 {
+		return page, errors.New("link is not available")
+	}
+
+	wire := &countingReader{r: resp.Body}
+	var decoded io.Reader = wire
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	compressed := encoding != "" && encoding != "identity"
+	if compressed {
+		decoder, ok := contentDecoders[encoding]
+		if !ok {
+			return page, errors.New("unknown content encoding " + encoding)
+		}
+		decoded, err = decoder(wire)
+		if err != nil {
+			return page, err
+		}
+	}
+
+	maxPageSize := scp.opts().maxPageSize
+	if maxPageSize <= 0 {
+		maxPageSize = defaultMaxPageSize
+	}
+
+	var body strings.Builder
+	tracker := newExtractionTracker()
+	chunk := make([]byte, fetchChunkSize)
+	finished := false
+	for !finished {
+		n, err := decoded.Read(chunk)
+		body.Write(chunk[:n])
+		if int64(body.Len()) > maxPageSize {
+			metrics.FetchBytesRead.Add(float64(wire.n))
+			return page, errPageTooLarge
+		}
+		if err == io.EOF {
+			finished = true
+		} else if err != nil {
+			metrics.FetchBytesRead.Add(float64(wire.n))
+			return page, err
+		} else if n > 0 && tracker.complete(body.String()) {
+			break
+		}
+	}
+
+	metrics.FetchBytesRead.Add(float64(wire.n))
+	if compressed && int64(body.Len()) > wire.n {
+		metrics.FetchBytesSaved.Add(float64(int64(body.Len()) - wire.n))
+	}
+	if !finished && !compressed && resp.ContentLength > wire.n {
+		metrics.FetchBytesSaved.Add(float64(resp.ContentLength - wire.n))
+	}
+
+	page.body = body.String()
+	if isBlockedPage(page.body) {
+		scp.reportBlock(ctx, url, host, proxy)
+		return page, errBlocked
+	}
+	scp.cooldowns.reset(host)
+
+	page.size = int64(body.Len())
+	page.etag = resp.Header.Get("ETag")
+	page.lastModified = resp.Header.Get("Last-Modified")
+	return page, nil
+}
+
+// Remembering validators of the page together with the result of its parsing
+func (scp *Scrapper) cachePage(url string, page fetchedPage, response config.GetPriceResponse) {
+	if page.etag == "" && page.lastModified == "" {
+		return
+	}
+	scp.cache.put(url, cachedPage{
+		etag:         page.etag,
+		lastModified: page.lastModified,
+		size:         page.size,
+		response:     response,
+	})
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+	"test_avito/src/metrics"
+)
+
+func TestFetchNotModified(t *testing.T) {
+	scp, _, _ := NewTestData()
+	scp.cache = newFetchCache()
+
+	var conditional []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprintln(w, avitoHTML)
+	}))
+	defer server.Close()
+	scp.Client = server.Client()
+
+	notModified := testutil.ToFloat64(metrics.FetchNotModified)
+	for i := 0; i < 2; i++ {
+		priceChan := make(chan config.GetPriceResponse, 1)
+		scp.getPrice(context.Background(), server.URL, priceChan)
+		value := <-priceChan
+		assert.Nil(t, value.Error)
+		assert.Equal(t, 8792009, value.Price)
+	}
+
+	assert.Equal(t, []string{"", `"v1"`}, conditional)
+	assert.Equal(t, notModified+1, testutil.ToFloat64(metrics.FetchNotModified))
+}
+
+func TestFetchGzip(t *testing.T) {
+	scp, _, _ := NewTestData()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Accept-Encoding"), "gzip")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		fmt.Fprintln(gz, avitoHTML)
+		gz.Close()
+	}))
+	defer server.Close()
+	scp.Client = server.Client()
+
+	saved := testutil.ToFloat64(metrics.FetchBytesSaved)
+	priceChan := make(chan config.GetPriceResponse, 1)
+	scp.getPrice(context.Background(), server.URL, priceChan)
+	value := <-priceChan
+	assert.Nil(t, value.Error)
+	assert.Equal(t, 8792009, value.Price)
+	assert.Greater(t, testutil.ToFloat64(metrics.FetchBytesSaved), saved)
+}
+
+func TestFetchBrotli(t *testing.T) {
+	scp, _, _ := NewTestData()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Accept-Encoding"), "br")
+		w.Header().Set("Content-Encoding", "br")
+		br := brotli.NewWriter(w)
+		fmt.Fprintln(br, avitoHTML)
+		br.Close()
+	}))
+	defer server.Close()
+	scp.Client = server.Client()
+
+	saved := testutil.ToFloat64(metrics.FetchBytesSaved)
+	priceChan := make(chan config.GetPriceResponse, 1)
+	scp.getPrice(context.Background(), server.URL, priceChan)
+	value := <-priceChan
+	assert.Nil(t, value.Error)
+	assert.Equal(t, 8792009, value.Price)
+	assert.Greater(t, testutil.ToFloat64(metrics.FetchBytesSaved), saved)
+}
+
+func TestFetchPageTooLarge(t *testing.T) {
+	scp, _, _ := NewTestData()
+	scp.options.Store(&scrapperOptions{maxPageSize: 1 << 10})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, strings.Repeat("<div></div>", 1000))
+	}))
+	defer server.Close()
+	scp.Client = server.Client()
+
+	_, err := scp.fetchPage(context.Background(), server.URL)
+	assert.Equal(t, errPageTooLarge, err)
+}
+
+func TestFetchStopsAfterExtraction(t *testing.T) {
+	scp, _, _ := NewTestData()
+	page := listingPageHTML + strings.Repeat("<div>comments and similar ads</div>", 100000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, page)
+	}))
+	defer server.Close()
+	scp.Client = server.Client()
+
+	fetched, err := scp.fetchPage(context.Background(), server.URL)
+	assert.Nil(t, err)
+	assert.Less(t, len(fetched.body), len(page))
+	assert.Equal(t, "BMW & Major", parseListingInfo(fetched.body).Seller)
+}
+
+func TestExtractionFindsMarkerSplitBetweenPieces(t *testing.T) {
+	tracker := newExtractionTracker()
+	split := strings.Index(listingPageHTML, sellerMarker) + 5
+	assert.False(t, tracker.complete(listingPageHTML[:split]))
+	assert.True(t, tracker.complete(listingPageHTML))
+}
+
+func TestExtractionParsesPageOnlyAfterMarkers(t *testing.T) {
+	tracker := newExtractionTracker()
+	body := ""
+	for i := 0; i < 100; i++ {
+		body += strings.Repeat("<div>comments and similar ads</div>", 1000)
+		assert.False(t, tracker.complete(body))
+	}
+	assert.Equal(t, 0, tracker.attempts)
+
+	// The markers are found, but the date of the ad is broken, so the page is read to the end
+	broken := strings.Replace(listingPageHTML, publishedAtMarker, publishedAtMarker+"yesterday", 1)
+	for i := 0; i < 100; i++ {
+		broken += "<div>comments and similar ads</div>"
+		assert.False(t, tracker.complete(body+broken))
+	}
+	assert.Equal(t, maxExtractionAttempts, tracker.attempts)
+}
+
+func TestFetchIsStoppedWithContext(t *testing.T) {
+	scp, _, _ := NewTestData()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	scp.Client = server.Client()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := scp.fetchPage(ctx, server.URL)
+	assert.ErrorIs(t, err, context.Canceled)
+}
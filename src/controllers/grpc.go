@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"test_avito/config"
+	"test_avito/src/rpc"
+	"test_avito/utils"
+)
+
+// gRPC API of the service. Uses the same operations as the HTTP handlers
+type GrpcServer struct {
+	rpc.UnimplementedNotificationServer
+
+	Env *EnvironmentNotification
+}
+
+func (s *GrpcServer) Subscribe(ctx context.Context, req *rpc.SubscribeRequest) (*rpc.Subscription, error) {
+	sub, err := s.Env.Subscribe(ctx, SubscriptionRequest{Url: req.Url, Email: req.Email})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toRpcSubscription(sub), nil
+}
+
+func (s *GrpcServer) Unsubscribe(ctx context.Context, req *rpc.UnsubscribeRequest) (*rpc.UnsubscribeResponse, error) {
+	err := s.Env.Unsubscribe(ctx, SubscriptionRequest{Url: req.Url, Token: req.Token})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &rpc.UnsubscribeResponse{}, nil
+}
+
+func (s *GrpcServer) ListSubscriptions(ctx context.Context, req *rpc.ListSubscriptionsRequest) (*rpc.ListSubscriptionsResponse, error) {
+	subs, err := s.Env.ListSubscriptions(ctx, req.Token)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &rpc.ListSubscriptionsResponse{}
+	for _, sub := range subs {
+		resp.Subscriptions = append(resp.Subscriptions, toRpcSubscription(sub))
+	}
+	return resp, nil
+}
+
+func (s *GrpcServer) GetPriceHistory(ctx context.Context, req *rpc.GetPriceHistoryRequest) (*rpc.GetPriceHistoryResponse, error) {
+	points, err := s.Env.PriceHistory(ctx, req.Url)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &rpc.GetPriceHistoryResponse{}
+	for _, point := range points {
+		resp.Points = append(resp.Points, &rpc.PricePoint{
+			Url:       point.Url,
+			Price:     int64(point.Price),
+			CheckedAt: timestamppb.New(point.CheckedAt),
+		})
+	}
+	return resp, nil
+}
+
+// Sending price changes found by the scrapper until the client goes away
+func (s *GrpcServer) WatchPriceChanges(req *rpc.WatchPriceChangesRequest, stream grpc.ServerStreamingServer[rpc.PriceChange]) error {
+	url := req.Url
+	if url != "" {
+		var err error
+		url, _, err = utils.CanonicalUrl(url)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, ErrInvalidUrl+": url is not valid")
+		}
+	}
+
+	var email string
+	if req.Token != "" {
+		var err error
+		email, err = s.Env.emailByToken(stream.Context(), req.Token)
+		if err != nil {
+			return grpcError(err)
+		}
+	}
+
+	// Price changes of all subscribers are streamed only with the admin token in the metadata
+	if url == "" && email == "" && !s.Env.isAdminToken(authorizationOf(stream.Context())) {
+		return status.Error(codes.Unauthenticated, ErrUnauthorized+": url, feed token or admin token is required")
+	}
+
+	events := s.Env.Scp.Events
+	if events == nil {
+		return status.Error(codes.Unavailable, "scrapper does not publish price changes")
+	}
+	changes := events.Subscribe(eventsBufferSize)
+	defer events.Unsubscribe(changes)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case change := <-changes:
+			if change.Kind != config.EventPriceChange || !matchEvent(change, url, email) {
+				continue
+			}
+			err := stream.Send(&rpc.PriceChange{
+				Url:       change.Url,
+				OldPrice:  int64(change.OldPrice),
+				NewPrice:  int64(change.NewPrice),
+				ChangedAt: timestamppb.New(change.Time),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Authorization metadata of the call, it has the same form as the HTTP header
+func authorizationOf(ctx context.Context) string {
+	values := metadata.ValueFromIncomingContext(ctx, "authorization")
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func toRpcSubscription(sub config.Subscription) *rpc.Subscription {
+	result := &rpc.Subscription{
+		AccVerified: sub.AccVerified,
+		Email:       sub.Email,
+		Price:       int64(sub.Price),
+		Url:         sub.Url,
+		AdId:        sub.AdId,
+		IsSearch:    sub.IsSearch,
+	}
+	if sub.Info != nil {
+		result.Listing = &rpc.ListingInfo{
+			Title:    sub.Info.Title,
+			ImageUrl: sub.Info.ImageUrl,
+			Location: sub.Info.Location,
+			Seller:   sub.Info.Seller,
+		}
+		if sub.Info.PublishedAt != nil {
+			result.Listing.PublishedAt = timestamppb.New(*sub.Info.PublishedAt)
+		}
+	}
+	return result
+}
+
+// Converting errors of the service operations to gRPC statuses
+func grpcError(err error) error {
+	apiErr, ok := err.(*ApiError)
+	if !ok {
+		return status.Error(codes.Internal, ErrInternal+": internal error")
+	}
+
+	code := codes.Internal
+	switch apiErr.Code {
+	case ErrInvalidBody, ErrInvalidUrl, ErrInvalidEmail:
+		code = codes.InvalidArgument
+	case ErrHostNotAllowed:
+		code = codes.InvalidArgument
+	case ErrTooManyRequests, ErrSubscriptionLimit:
+		code = codes.ResourceExhausted
+	case ErrListingUnreachable:
+		code = codes.FailedPrecondition
+	case ErrDuplicateSubscription:
+		code = codes.AlreadyExists
+	case ErrSubscriptionNotFound, ErrConfirmationNotFound, ErrNotFound:
+		code = codes.NotFound
+	case ErrTemporarilyUnavailable:
+		code = codes.Unavailable
+	case ErrUnauthorized:
+		code = codes.Unauthenticated
+	}
+	return status.Error(code, apiErr.Error())
+}
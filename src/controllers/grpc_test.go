@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"test_avito/config"
+	"test_avito/src/rpc"
+)
+
+func newTestGrpcClient(t *testing.T, env *EnvironmentNotification) rpc.NotificationClient {
+	lis := bufconn.Listen(1 << 20)
+	server := rpc.NewServer(&GrpcServer{Env: env})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return rpc.NewNotificationClient(conn)
+}
+
+func TestGrpcListSubscriptions(t *testing.T) {
+	scp, _, mock := NewTestData()
+	env := &EnvironmentNotification{Db: scp.Db, Scp: scp}
+	client := newTestGrpcClient(t, env)
+
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WithArgs("feed-token").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("d_kokin@inbox.ru"))
+	publishedAt := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"acc_verified", "email", "price", "url", "ad_id", "is_search",
+		"title", "image_url", "location", "seller", "published_at"}).
+		AddRow(true, "d_kokin@inbox.ru", 100, "https://www.avito.ru/moskva/bmw_1791027290", 1791027290, false,
+			"BMW M5, 2019", "https://img.avito.st/1.jpg", "Москва", "BMW Major", publishedAt)
+	mock.ExpectQuery("SELECT s.acc_verified, s.email, s.price, s.url").
+		WithArgs("d_kokin@inbox.ru").
+		WillReturnRows(rows)
+
+	resp, err := client.ListSubscriptions(context.Background(), &rpc.ListSubscriptionsRequest{Token: "feed-token"})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Subscriptions, 1)
+	assert.Equal(t, int64(1791027290), resp.Subscriptions[0].AdId)
+	assert.Equal(t, int64(100), resp.Subscriptions[0].Price)
+	assert.Equal(t, "BMW M5, 2019", resp.Subscriptions[0].Listing.Title)
+	assert.True(t, publishedAt.Equal(resp.Subscriptions[0].Listing.PublishedAt.AsTime()))
+}
+
+func TestGrpcSubscribeInvalidEmail(t *testing.T) {
+	scp, testServer, _ := NewTestData()
+	env := &EnvironmentNotification{Db: scp.Db, Scp: scp}
+	client := newTestGrpcClient(t, env)
+
+	_, err := client.Subscribe(context.Background(), &rpc.SubscribeRequest{Url: testServer.URL, Email: "badEmail"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGrpcUnsubscribeNotFound(t *testing.T) {
+	scp, testServer, mock := NewTestData()
+	env := &EnvironmentNotification{Db: scp.Db, Scp: scp}
+	client := newTestGrpcClient(t, env)
+
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WithArgs("feed-token").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("d_kokin@inbox.ru"))
+	mock.ExpectExec("DELETE FROM subscription").
+		WithArgs("d_kokin@inbox.ru", testServer.URL).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, err := client.Unsubscribe(context.Background(), &rpc.UnsubscribeRequest{Url: testServer.URL, Token: "feed-token"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGrpcUnsubscribeWithoutToken(t *testing.T) {
+	scp, testServer, _ := NewTestData()
+	env := &EnvironmentNotification{Db: scp.Db, Scp: scp}
+	client := newTestGrpcClient(t, env)
+
+	_, err := client.Unsubscribe(context.Background(), &rpc.UnsubscribeRequest{Url: testServer.URL})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestGrpcGetPriceHistory(t *testing.T) {
+	scp, testServer, mock := NewTestData()
+	env := &EnvironmentNotification{Db: scp.Db, Scp: scp}
+	client := newTestGrpcClient(t, env)
+
+	checkedAt := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT url, price, checked_at FROM price_history").
+		WithArgs(testServer.URL).
+		WillReturnRows(sqlmock.NewRows([]string{"url", "price", "checked_at"}).
+			AddRow(testServer.URL, 100, checkedAt))
+
+	resp, err := client.GetPriceHistory(context.Background(), &rpc.GetPriceHistoryRequest{Url: testServer.URL})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Points, 1)
+	assert.True(t, checkedAt.Equal(resp.Points[0].CheckedAt.AsTime()))
+}
+
+func TestGrpcWatchPriceChanges(t *testing.T) {
+	scp, _, mock := NewTestData()
+	scp.Events = NewBroker()
+	env := &EnvironmentNotification{Db: scp.Db, Scp: scp}
+	client := newTestGrpcClient(t, env)
+
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WithArgs("feed-token").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("d_kokin@inbox.ru"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.WatchPriceChanges(ctx, &rpc.WatchPriceChangesRequest{Token: "feed-token"})
+	assert.Nil(t, err)
+
+	// Waiting for the server to subscribe to the broker
+	for !scp.Events.HasSubscribers() {
+		time.Sleep(time.Millisecond)
+	}
+
+	changedAt := time.Now().UTC()
+	scp.Events.Publish(config.Event{Kind: config.EventPriceChange, Url: "other", OldPrice: 1, NewPrice: 2,
+		Emails: []string{"other@inbox.ru"}})
+	scp.Events.Publish(config.Event{Kind: config.EventScrapeError, Url: "mine", Emails: []string{"d_kokin@inbox.ru"}})
+	scp.Events.Publish(config.Event{Kind: config.EventPriceChange, Url: "mine", OldPrice: 10, NewPrice: 20,
+		Emails: []string{"d_kokin@inbox.ru"}, Time: changedAt})
+
+	change, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, "mine", change.Url)
+	assert.Equal(t, int64(10), change.OldPrice)
+	assert.Equal(t, int64(20), change.NewPrice)
+	assert.True(t, changedAt.Equal(change.ChangedAt.AsTime()))
+}
+
+func TestGrpcWatchAllPriceChangesRequiresAdminToken(t *testing.T) {
+	scp, _, _ := NewTestData()
+	scp.Events = NewBroker()
+	env := &EnvironmentNotification{Db: scp.Db, Scp: scp, AdminToken: testAdminToken}
+	client := newTestGrpcClient(t, env)
+
+	stream, err := client.WatchPriceChanges(context.Background(), &rpc.WatchPriceChangesRequest{})
+	assert.Nil(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(context.Background(),
+		"authorization", "Bearer "+testAdminToken))
+	defer cancel()
+	stream, err = client.WatchPriceChanges(ctx, &rpc.WatchPriceChangesRequest{})
+	assert.Nil(t, err)
+	for !scp.Events.HasSubscribers() {
+		time.Sleep(time.Millisecond)
+	}
+	scp.Events.Publish(config.Event{Kind: config.EventPriceChange, Url: "other", OldPrice: 1, NewPrice: 2})
+	change, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, "other", change.Url)
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"test_avito/src/services"
+)
+
+// Worker that has not come back to the queue for this time is considered stuck.
+// It is the lease of the check, after it other instances take the check anyway
+const workerStallTimeout = 2 * time.Minute
+
+// Timeout of every check of the readiness
+const readinessCheckTimeout = 2 * time.Second
+
+// Mail server is checked not more often than once in this interval, probes come every few seconds
+var smtpCheckInterval = time.Minute
+
+// Activity of the scrapper workers shared by all copies of the Scrapper
+type scrapperActivity struct {
+	running  int32
+	lastLoop int64
+}
+
+func (a *scrapperActivity) setRunning(running bool) {
+	if a == nil {
+		return
+	}
+	value := int32(0)
+	if running {
+		value = 1
+		a.touch()
+	}
+	atomic.StoreInt32(&a.running, value)
+}
+
+// Called by workers every time they come to the queue
+func (a *scrapperActivity) touch() {
+	if a == nil {
+		return
+	}
+	atomic.StoreInt64(&a.lastLoop, time.Now().UnixNano())
+}
+
+// True if the scrapper is started and its workers have come to the queue recently
+func (a *scrapperActivity) alive() bool {
+	if a == nil || atomic.LoadInt32(&a.running) == 0 {
+		return false
+	}
+	return time.Since(time.Unix(0, atomic.LoadInt64(&a.lastLoop))) < workerStallTimeout
+}
+
+// Result of the last check of the mail server
+type smtpStatus struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+var lastSmtpCheck smtpStatus
+
+// Function that checks the mail server if the last check is too old
+var checkSmtp = func() error {
+	lastSmtpCheck.mu.Lock()
+	defer lastSmtpCheck.mu.Unlock()
+	if time.Since(lastSmtpCheck.checkedAt) > smtpCheckInterval {
+		lastSmtpCheck.err = services.CheckSmtp(readinessCheckTimeout)
+		lastSmtpCheck.checkedAt = time.Now()
+	}
+	return lastSmtpCheck.err
+}
+
+// Response of /healthz
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Hosts  []HostParseStatus `json:"hosts"`
+}
+
+// Handler that shows if the process is alive. Degraded parser does not make the service unhealthy,
+// restarts do not help against the new markup, so the status is 200 anyway
+func (env *EnvironmentNotification) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	response := HealthResponse{Status: "ok", Hosts: env.Scp.canary.Status()}
+	for _, host := range response.Hosts {
+		if host.Degraded {
+			response.Status = "degraded"
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Statuses of the components
+const (
+	componentOk       = "ok"
+	componentFailed   = "fail"
+	componentDisabled = "disabled"
+)
+
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Response of /readyz
+type ReadinessResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+func componentStatus(err error) ComponentStatus {
+	if err != nil {
+		return ComponentStatus{Status: componentFailed, Error: err.Error()}
+	}
+	return ComponentStatus{Status: componentOk}
+}
+
+// Handler that shows if the service can serve requests: the database is reachable, its scheme is created,
+// workers of the scrapper are running and the mail server answers. Otherwise the status is 503
+func (env *EnvironmentNotification) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	components := make(map[string]ComponentStatus)
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+	defer cancel()
+	components["database"] = componentStatus(env.Db.PingContext(ctx))
+
+	components["migrations"] = ComponentStatus{Status: componentOk}
+	if !services.SchemaReady() {
+		components["migrations"] = ComponentStatus{Status: componentFailed, Error: "scheme of the database is not created yet"}
+	}
+
+	components["scrapper"] = ComponentStatus{Status: componentOk}
+	if !env.Scp.activity.alive() {
+		components["scrapper"] = ComponentStatus{Status: componentFailed, Error: "workers of the scrapper are not running"}
+	}
+
+	// Without the mail account notifications are not sent at all, so the mail server does not matter
+	if !services.MailAccountSet() {
+		components["smtp"] = ComponentStatus{Status: componentDisabled}
+	} else {
+		components["smtp"] = componentStatus(checkSmtp())
+	}
+
+	response := ReadinessResponse{Status: "ready", Components: components}
+	status := http.StatusOK
+	for _, component := range components {
+		if component.Status == componentFailed {
+			response.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, response)
+}
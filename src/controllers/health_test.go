@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+	"test_avito/src/services"
+)
+
+func getReadiness(t *testing.T, env *EnvironmentNotification) (int, ReadinessResponse) {
+	w := httptest.NewRecorder()
+	NewRouter(env).ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	var readiness ReadinessResponse
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&readiness))
+	return w.Code, readiness
+}
+
+func withServiceMail(mail string) func() {
+	services.SetMailAccount(config.Mail{Address: mail})
+	return func() {
+		services.SetMailAccount(config.Mail{})
+	}
+}
+
+func TestReadyzBeforeStart(t *testing.T) {
+	defer withServiceMail("")()
+	scp, _, _ := NewTestData()
+	scp.activity = &scrapperActivity{}
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+
+	code, readiness := getReadiness(t, &env)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not_ready", readiness.Status)
+	assert.Equal(t, componentOk, readiness.Components["database"].Status)
+	assert.Equal(t, componentFailed, readiness.Components["scrapper"].Status)
+	assert.Equal(t, componentDisabled, readiness.Components["smtp"].Status)
+}
+
+func TestReadyzAfterStart(t *testing.T) {
+	defer withServiceMail("service@example.com")()
+	scp, _, mock := NewTestData()
+	scp.activity = &scrapperActivity{}
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	assert.Nil(t, services.Setup("../db/init.sql", scp.Db))
+	scp.activity.setRunning(true)
+
+	smtpErr := errors.New("connection refused")
+	oldCheck := checkSmtp
+	defer func() { checkSmtp = oldCheck }()
+	checkSmtp = func() error { return smtpErr }
+
+	code, readiness := getReadiness(t, &env)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, componentOk, readiness.Components["migrations"].Status)
+	assert.Equal(t, componentOk, readiness.Components["scrapper"].Status)
+	assert.Equal(t, ComponentStatus{Status: componentFailed, Error: "connection refused"}, readiness.Components["smtp"])
+
+	checkSmtp = func() error { return nil }
+	code, readiness = getReadiness(t, &env)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", readiness.Status)
+}
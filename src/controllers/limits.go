@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/peer"
+
+	"test_avito/config"
+	"test_avito/src/metrics"
+)
+
+// Number of the keys of the rate limiter after which the idle keys are removed
+const rateLimiterCleanup = 10000
+
+// Token bucket for every key: the bucket holds up to limit tokens and is filled completely in the period
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	period  time.Duration
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(limit int, period time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, period: period, buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+// Function that takes a token of the key. Returns the time until the next token if the bucket is empty
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	now := l.now()
+	if len(l.buckets) >= rateLimiterCleanup {
+		l.removeFull(now)
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.limit), updated: now}
+		l.buckets[key] = bucket
+	}
+	l.fill(bucket, now)
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) * float64(l.period) / float64(l.limit))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// Function that changes the limit, tokens already taken stay taken
+func (l *rateLimiter) configure(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+}
+
+// Must be called with the lock
+func (l *rateLimiter) fill(bucket *tokenBucket, now time.Time) {
+	bucket.tokens += now.Sub(bucket.updated).Seconds() * float64(l.limit) / l.period.Seconds()
+	if bucket.tokens > float64(l.limit) {
+		bucket.tokens = float64(l.limit)
+	}
+	bucket.updated = now
+}
+
+// Full buckets are the same as missing ones. Must be called with the lock
+func (l *rateLimiter) removeFull(now time.Time) {
+	for key, bucket := range l.buckets {
+		l.fill(bucket, now)
+		if bucket.tokens >= float64(l.limit) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Protection of the subscription against the abuse: rate limits by IP address and by email,
+// caps of the subscriptions of one email and the list of the allowed hosts
+type SubscribeLimits struct {
+	byIp    *rateLimiter
+	byEmail *rateLimiter
+
+	mu     sync.RWMutex
+	limits config.Limits
+}
+
+func NewSubscribeLimits(cnf config.Limits) *SubscribeLimits {
+	return &SubscribeLimits{
+		byIp:    newRateLimiter(cnf.IpPerMinute, time.Minute),
+		byEmail: newRateLimiter(cnf.EmailPerHour, time.Hour),
+		limits:  cnf,
+	}
+}
+
+// Function that applies the reloaded limits
+func (l *SubscribeLimits) configure(cnf config.Limits) {
+	if l == nil {
+		return
+	}
+	l.byIp.configure(cnf.IpPerMinute)
+	l.byEmail.configure(cnf.EmailPerHour)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = cnf
+}
+
+func (l *SubscribeLimits) current() config.Limits {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.limits
+}
+
+// True if the url belongs to one of the allowed hosts or to their subdomains
+func (l *SubscribeLimits) hostAllowed(host string) bool {
+	if l == nil {
+		return true
+	}
+	allowed := l.current().AllowedHosts
+	if len(allowed) == 0 {
+		return true
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, allowedHost := range allowed {
+		allowedHost = strings.ToLower(allowedHost)
+		if host == allowedHost || strings.HasSuffix(host, "."+allowedHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function that takes a request of the IP address. Requests without the address are not limited
+func (l *SubscribeLimits) allowIp(ip string) error {
+	if l == nil || ip == "" {
+		return nil
+	}
+	if ok, wait := l.byIp.allow(ip); !ok {
+		return rejectSubscription("ip", newRateLimitError("too many subscription requests from the address", "", wait))
+	}
+	return nil
+}
+
+func (l *SubscribeLimits) allowEmail(email string) error {
+	if l == nil {
+		return nil
+	}
+	if ok, wait := l.byEmail.allow(strings.ToLower(email)); !ok {
+		return rejectSubscription("email", newRateLimitError("too many subscription requests for the email", "email", wait))
+	}
+	return nil
+}
+
+// Function that checks the caps of the subscriptions of the email
+func (l *SubscribeLimits) checkCaps(ctx context.Context, env *EnvironmentNotification, email string) error {
+	if l == nil {
+		return nil
+	}
+	limits := l.current()
+	if limits.MaxSubscriptions <= 0 && limits.MaxUnconfirmed <= 0 {
+		return nil
+	}
+
+	total, unconfirmed, err := env.Db.CountSubscriptions(ctx, email)
+	if err != nil {
+		return newApiError(http.StatusInternalServerError, ErrInternal, "subscriptions were not counted", "")
+	}
+
+	if limits.MaxSubscriptions > 0 && total >= limits.MaxSubscriptions {
+		return rejectSubscription("max_subscriptions",
+			newApiError(http.StatusForbidden, ErrSubscriptionLimit, "email has too many subscriptions", "email"))
+	}
+	if limits.MaxUnconfirmed > 0 && unconfirmed >= limits.MaxUnconfirmed {
+		return rejectSubscription("max_unconfirmed",
+			newApiError(http.StatusForbidden, ErrSubscriptionLimit, "email has too many subscriptions waiting for the confirmation", "email"))
+	}
+	return nil
+}
+
+func newRateLimitError(message string, field string, wait time.Duration) *ApiError {
+	err := newApiError(http.StatusTooManyRequests, ErrTooManyRequests, message, field)
+	err.RetryAfter = int(math.Ceil(wait.Seconds()))
+	if err.RetryAfter < 1 {
+		err.RetryAfter = 1
+	}
+	return err
+}
+
+func rejectSubscription(reason string, err *ApiError) *ApiError {
+	metrics.SubscribeRejected.WithLabelValues(reason).Inc()
+	return err
+}
+
+type clientIpKey struct{}
+
+// Context of the request that knows the IP address of the client
+func withClientIp(ctx context.Context, r *http.Request) context.Context {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return context.WithValue(ctx, clientIpKey{}, host)
+}
+
+// IP address of the client of the HTTP or gRPC request, empty if it is unknown
+func clientIp(ctx context.Context) string {
+	if ip, ok := ctx.Value(clientIpKey{}).(string); ok {
+		return ip
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			return p.Addr.String()
+		}
+		return host
+	}
+	return ""
+}
+
+// Host of the url without the port, empty if the url is not valid
+func urlHost(rawUrl string) string {
+	u, err := neturl.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+)
+
+func TestRateLimiterRefillsTokens(t *testing.T) {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(2, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	ok, _ := limiter.allow("192.0.2.1")
+	assert.True(t, ok)
+	ok, _ = limiter.allow("192.0.2.1")
+	assert.True(t, ok)
+	ok, wait := limiter.allow("192.0.2.1")
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, wait)
+	// Other keys have their own buckets
+	ok, _ = limiter.allow("192.0.2.2")
+	assert.True(t, ok)
+
+	now = now.Add(30 * time.Second)
+	ok, _ = limiter.allow("192.0.2.1")
+	assert.True(t, ok)
+
+	limiter.configure(0)
+	ok, _ = limiter.allow("192.0.2.1")
+	assert.True(t, ok)
+}
+
+func TestHostAllowed(t *testing.T) {
+	limits := NewSubscribeLimits(config.Limits{AllowedHosts: []string{"avito.ru"}})
+	assert.True(t, limits.hostAllowed("avito.ru"))
+	assert.True(t, limits.hostAllowed("m.avito.ru"))
+	assert.True(t, limits.hostAllowed("WWW.AVITO.RU."))
+	assert.False(t, limits.hostAllowed("notavito.ru"))
+	assert.False(t, limits.hostAllowed("avito.ru.example.com"))
+	assert.False(t, limits.hostAllowed(""))
+
+	var noLimits *SubscribeLimits
+	assert.True(t, noLimits.hostAllowed("example.com"))
+}
+
+func subscribeRequest(env *EnvironmentNotification, url string, email string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	NewRouter(env).ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/subscribe?url="+url+"&email="+email, nil))
+	return w
+}
+
+func TestSubscribeRejectsNotAllowedHost(t *testing.T) {
+	scp, testServer, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp,
+		Limits: NewSubscribeLimits(config.Limits{AllowedHosts: []string{"avito.ru"}})}
+
+	w := subscribeRequest(&env, testServer.URL, "d_kokin@inbox.ru")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), ErrHostNotAllowed)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSubscribeIsLimitedByIp(t *testing.T) {
+	scp, _, _ := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp,
+		Limits: NewSubscribeLimits(config.Limits{IpPerMinute: 1, AllowedHosts: []string{"avito.ru"}})}
+
+	w := subscribeRequest(&env, "https://example.com/1", "d_kokin@inbox.ru")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Wrong requests use the limit too
+	w = subscribeRequest(&env, "https://example.com/2", "d_kokin@inbox.ru")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), ErrTooManyRequests)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
+
+func TestSubscribeIsLimitedByEmail(t *testing.T) {
+	scp, _, _ := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp,
+		Limits: NewSubscribeLimits(config.Limits{EmailPerHour: 1, AllowedHosts: []string{"avito.ru"}})}
+
+	subscribeRequest(&env, "https://example.com/1", "d_kokin@inbox.ru")
+	w := subscribeRequest(&env, "https://example.com/2", "D_Kokin@inbox.ru")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), `"field":"email"`)
+
+	w = subscribeRequest(&env, "https://example.com/3", "other@inbox.ru")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSubscribeIsLimitedBySubscriptionCaps(t *testing.T) {
+	scp, testServer, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp,
+		Limits: NewSubscribeLimits(config.Limits{MaxSubscriptions: 10, MaxUnconfirmed: 3})}
+
+	mock.ExpectQuery("SELECT count").WithArgs("d_kokin@inbox.ru").
+		WillReturnRows(mock.NewRows([]string{"total", "unconfirmed"}).AddRow(10, 0))
+	w := subscribeRequest(&env, testServer.URL, "d_kokin@inbox.ru")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), ErrSubscriptionLimit)
+
+	mock.ExpectQuery("SELECT count").WithArgs("d_kokin@inbox.ru").
+		WillReturnRows(mock.NewRows([]string{"total", "unconfirmed"}).AddRow(3, 3))
+	w = subscribeRequest(&env, testServer.URL, "d_kokin@inbox.ru")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "waiting for the confirmation")
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestOperatorSubscriptionIsNotLimited(t *testing.T) {
+	scp, _, _ := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp,
+		Limits: NewSubscribeLimits(config.Limits{EmailPerHour: 1, AllowedHosts: []string{"avito.ru"}})}
+
+	for i := 0; i < 2; i++ {
+		_, err := env.SubscribeConfirmed(context.Background(),
+			SubscriptionRequest{Url: "https://example.com/1", Email: "d_kokin@inbox.ru"})
+		// The allowed hosts are checked for the operator too
+		assert.Equal(t, ErrHostNotAllowed, err.(*ApiError).Code)
+	}
+}
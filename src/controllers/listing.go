@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"html"
+	"strings"
+	"time"
+
+	"test_avito/config"
+)
+
+// Markers of the ad page of avito
+const (
+	titleMarker       = `itemprop="name"`
+	ogTitleMarker     = `property="og:title" content="`
+	ogImageMarker     = `property="og:image" content="`
+	locationMarker    = `class="item-address__string"`
+	sellerMarker      = `data-marker="seller-info/name"`
+	publishedAtMarker = `itemprop="datePublished" content="`
+)
+
+// Function that takes the description of the ad from its page. Fields are left empty if they were not found
+func parseListingInfo(body string) config.ListingInfo {
+	info := config.ListingInfo{
+		Title:    parseText(body, titleMarker),
+		ImageUrl: html.UnescapeString(parsePrice(body, ogImageMarker, `"`)),
+		Location: parseText(body, locationMarker),
+		Seller:   parseText(body, sellerMarker),
+	}
+	if info.Title == "" {
+		info.Title = strings.TrimSpace(html.UnescapeString(parsePrice(body, ogTitleMarker, `"`)))
+	}
+
+	publishedAt, err := time.Parse(time.RFC3339, parsePrice(body, publishedAtMarker, `"`))
+	if err == nil {
+		info.PublishedAt = &publishedAt
+	}
+	return info
+}
+
+func isEmptyInfo(info config.ListingInfo) bool {
+	return info == config.ListingInfo{}
+}
+
+// Function that returns the first non empty text after the tag with the marker.
+// Nested tags like links are skipped, but not more than a few of them
+func parseText(body string, marker string) string {
+	s := strings.Index(body, marker)
+	if s == -1 {
+		return ""
+	}
+
+	buffer := body[s+len(marker):]
+	for i := 0; i < 4; i++ {
+		begin := strings.Index(buffer, ">")
+		if begin == -1 {
+			return ""
+		}
+		buffer = buffer[begin+1:]
+
+		end := strings.Index(buffer, "<")
+		if end == -1 {
+			return ""
+		}
+		text := strings.Join(strings.Fields(html.UnescapeString(buffer[:end])), " ")
+		if text != "" {
+			return text
+		}
+		buffer = buffer[end:]
+	}
+	return ""
+}
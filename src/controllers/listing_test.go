@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+)
+
+const listingPageHTML = `<html><head>
+<meta property="og:title" content="BMW M5, 2019 купить в Москве | Авито">
+<meta property="og:image" content="https://img.avito.st/640x480/1.jpg?cqp=1&amp;t=2">
+<meta itemprop="datePublished" content="2020-10-01T12:00:00+03:00">
+</head><body>
+<h1 class="title-info-title"><span class="title-info-title-text" itemprop="name">BMW M5, 2019</span></h1>
+<div class="item-address"><span class="item-address__string">
+  Москва, Ленинградский проспект, 39
+</span></div>
+<div class="seller-info-name" data-marker="seller-info/name">
+  <a href="/user/abc/profile">BMW &amp; Major</a>
+</div>
+<script>window.dataLayer = [{"dynx_prodid":1791027290,"dynx_price":8792009,"dynx_category":"avtomobili"}];</script>
+</body></html>`
+
+func TestParseListingInfo(t *testing.T) {
+	info := parseListingInfo(listingPageHTML)
+
+	assert.Equal(t, "BMW M5, 2019", info.Title)
+	assert.Equal(t, "https://img.avito.st/640x480/1.jpg?cqp=1&t=2", info.ImageUrl)
+	assert.Equal(t, "Москва, Ленинградский проспект, 39", info.Location)
+	assert.Equal(t, "BMW & Major", info.Seller)
+	assert.NotNil(t, info.PublishedAt)
+	assert.True(t, time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC).Equal(*info.PublishedAt))
+}
+
+func TestParseListingInfoOgTitle(t *testing.T) {
+	info := parseListingInfo(`<meta property="og:title" content="Audi RS6 &quot;Avant&quot;">`)
+
+	assert.Equal(t, config.ListingInfo{Title: `Audi RS6 "Avant"`}, info)
+}
+
+func TestParseListingInfoEmpty(t *testing.T) {
+	assert.True(t, isEmptyInfo(parseListingInfo(avitoHTML)))
+}
+
+func TestWorkerSavesListingInfo(t *testing.T) {
+	scp, _, sqlMock := NewTestData()
+	listingServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, listingPageHTML)
+	}))
+	defer listingServer.Close()
+	scp.Client = listingServer.Client()
+
+	sqlMock.ExpectExec("INSERT INTO listing").
+		WithArgs(listingServer.URL, "BMW M5, 2019", "https://img.avito.st/640x480/1.jpg?cqp=1&t=2",
+			"Москва, Ленинградский проспект, 39", "BMW & Major", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	scp.checkPair(config.CheckPriceRequest{OldPrice: 8792009, Url: listingServer.URL})
+	assert.Nil(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestLongListingInfoIsCut(t *testing.T) {
+	scp, _, sqlMock := NewTestData()
+	longTitle := strings.Repeat("Ж", 300)
+	info := config.ListingInfo{Title: longTitle, Location: "Москва", Seller: strings.Repeat("s", 257),
+		ImageUrl: "https://img.avito.st/" + strings.Repeat("a", 512)}
+
+	// Texts are cut by characters, the image url is not a link after cutting, so it is dropped
+	sqlMock.ExpectExec("INSERT INTO listing").
+		WithArgs("url", strings.Repeat("Ж", 256), "", "Москва", strings.Repeat("s", 256), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	assert.Nil(t, scp.Db.SaveListingInfo(context.Background(), "url", info))
+	assert.Nil(t, sqlMock.ExpectationsWereMet())
+}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"test_avito/src/metrics"
+)
+
+// Writer that remembers the status of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Events are streamed, so the writer has to be flushed through the wrapper
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Template of the matched route, so /feed/{token}.atom is one route for all tokens
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unknown"
+}
+
+// Middleware that counts requests and their duration by the template of the route
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		started := time.Now()
+		next.ServeHTTP(rec, r)
+
+		metrics.HttpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(started).Seconds())
+		metrics.HttpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+	})
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+	"test_avito/src/metrics"
+)
+
+func TestMetricsCountRequestsByRoute(t *testing.T) {
+	scp, _, _ := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	router := NewRouter(&env)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/feed/abc.atom", nil))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `avito_http_requests_total{method="GET",route="/healthz",status="200"}`)
+	assert.True(t, strings.Contains(body, `route="/api/v1/feed/{token}.atom"`))
+}
+
+func TestMetricsCountScrapeOutcomes(t *testing.T) {
+	scp, _, _ := NewTestData()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/removed" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintln(w, avitoHTML)
+	}))
+	defer server.Close()
+	scp.Client = server.Client()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	for _, path := range []string{"/item", "/removed"} {
+		priceChan := make(chan config.GetPriceResponse, 1)
+		scp.getPrice(context.Background(), server.URL+path, priceChan)
+		<-priceChan
+	}
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ScrapeAttempts.WithLabelValues(host, outcomeOk)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ScrapeAttempts.WithLabelValues(host, outcomeRemoved)))
+}
+
+func TestMetricsTimeQueriesByOperation(t *testing.T) {
+	scp, _, mock := NewTestData()
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM scrape_job").
+		WillReturnRows(mock.NewRows([]string{"count"}).AddRow(3))
+
+	scp.updateQueueDepth()
+
+	w := httptest.NewRecorder()
+	NewRouter(&EnvironmentNotification{Db: scp.Db, Scp: scp}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `avito_db_query_duration_seconds_count{query="CountDueChecks"}`)
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.QueueDepth))
+}
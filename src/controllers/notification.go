@@ -1,100 +1,140 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
-	"test_avito/config"
+	"strings"
+
 	"test_avito/src/services"
-	"test_avito/utils"
 )
 
 type EnvironmentNotification struct {
 	Db  services.DatastoreNotification
 	Scp Scrapper
+
+	// Bearer token of the admin API, the API is disabled without it
+	AdminToken string
+
+	// Limits of the subscription requests, nothing is limited without them
+	Limits *SubscribeLimits
+}
+
+// Arguments of the subscription request. Can be passed in the address bar or as a JSON body
+type SubscriptionRequest struct {
+	Url   string `json:"url"`
+	Email string `json:"email"`
+	// Token of the subscriber's feed, it is required to remove and list the subscriptions
+	Token string `json:"token,omitempty"`
+}
+
+// Limit of JSON bodies of the requests, arguments of the API are a few short strings
+const maxBodySize = 64 << 10
+
+// Function that decodes the JSON body of the request. Bodies larger than maxBodySize are not read to the end
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(v)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return newApiError(http.StatusRequestEntityTooLarge, ErrInvalidBody, "request body is too large", "")
+	}
+	if err != nil {
+		return newApiError(http.StatusBadRequest, ErrInvalidBody, "request body is not a valid JSON", "")
+	}
+	return nil
+}
+
+// Function that takes arguments of the subscription from JSON body or from the address bar
+func readSubscriptionRequest(w http.ResponseWriter, r *http.Request) (SubscriptionRequest, error) {
+	var req SubscriptionRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := decodeBody(w, r, &req)
+		if err != nil {
+			return req, err
+		}
+	}
+
+	query := r.URL.Query()
+	if req.Url == "" {
+		req.Url = query.Get("url")
+	}
+	if req.Email == "" {
+		req.Email = query.Get("email")
+	}
+	if req.Token == "" {
+		req.Token = query.Get("token")
+	}
+	return req, nil
 }
 
 // The main handler of the service. Accepts subscription requests
 func (env *EnvironmentNotification) SubscriptionHandler(w http.ResponseWriter, r *http.Request) {
-	// Taking the url from the address bar arguments and validate the correctness of the url
-	url := r.URL.Query().Get("url")
-	err := utils.CheckUrl(url)
+	req, err := readSubscriptionRequest(w, r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
 
-	// Taking the email from the address bar arguments and validate the correctness of the email
-	email := r.URL.Query().Get("email")
-	err = utils.CheckEmail(email)
+	sub, err := env.Subscribe(withClientIp(r.Context(), r), req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, sub)
+}
 
-	// Making a request to the avito website to get the price
-	// 400th error in case of a nonexistent link
-	priceChan := make(chan config.GetPriceResponse, 1)
-	go env.Scp.getPrice(url, priceChan)
-
-	// Checking whether the user has confirmed the specified email
-	authChan := make(chan bool, 1)
-	go env.Db.IsAuthorized(email, authChan)
-
-	// Checking the case when the same user sends a repeated url
-	dupChan := make(chan bool, 1)
-	go env.Db.IsDuplicate(email, url, dupChan)
+// Handler for user's email confirmation
+func (env *EnvironmentNotification) ConfirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	// Taking unique hash from the address bar arguments
+	hash := r.URL.Query().Get("hash")
 
-	response := <-priceChan
-	if response.Error != nil || response.Price == -1 {
-		w.WriteHeader(http.StatusBadRequest)
+	// Confirm email or send a new email if the confirmation time has expired
+	token, err := env.ConfirmEmail(r.Context(), hash)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	isDuplicate := <-dupChan
-	isAuthorized := <-authChan
-
-	// 400th error in case duplicate url
-	if isDuplicate {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	response := map[string]string{"status": "ok"}
+	if token != "" {
+		response["feed_atom"] = feedPath(token, "atom")
+		response["feed_rss"] = feedPath(token, "rss")
 	}
+	writeJSON(w, http.StatusOK, response)
+}
 
-	sub := config.Subscription{
-		AccVerified: isAuthorized,
-		Email:       email,
-		Url:         url,
-		Price:       response.Price,
+// Handler that removes the subscription to the url, the subscriber is found by the feed token
+func (env *EnvironmentNotification) UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := readSubscriptionRequest(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
 
-	// Saving subscription info to database
-	// 500th error in case of internal database error
-	err = env.Db.SaveSubscription(sub)
+	err = env.Unsubscribe(r.Context(), req)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
 
-	// Do not sending a confirmation email if the user has already confirmed it
-	if !isAuthorized {
-		err = env.Db.RecordMailConfirm(email)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+// Handler that returns all subscriptions of the owner of the feed token
+func (env *EnvironmentNotification) ListSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	subs, err := env.ListSubscriptions(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		writeError(w, err)
+		return
 	}
-
-	w.WriteHeader(http.StatusOK)
+	writeJSON(w, http.StatusOK, subs)
 }
 
-// Handler for user's email confirmation
-func (env *EnvironmentNotification) ConfirmEmailHandler(w http.ResponseWriter, r *http.Request) {
-	// Taking unique hash from the address bar arguments
-	hash := r.URL.Query().Get("hash")
-
-	// Confirm email or send a new email if the confirmation time has expired
-	err := env.Db.Confirm(hash)
+// Handler that returns recorded prices of the ad
+func (env *EnvironmentNotification) PriceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	points, err := env.PriceHistory(r.Context(), r.URL.Query().Get("url"))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	writeJSON(w, http.StatusOK, points)
 }
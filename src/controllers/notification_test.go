@@ -1,8 +1,11 @@
 package controllers
 
 import (
+	"context"
+	"database/sql"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -135,7 +138,7 @@ func TestSubscriptionHandlerAuthInternalError(t *testing.T) {
 		Scp: scp,
 	}
 
-	mock.ExpectQuery("SELECT DISTINCT acc_verified").
+	mock.ExpectQuery("SELECT COALESCE\\(bool_or\\(acc_verified\\), false\\)").
 		WithArgs("d_kokin@inbox.ru").
 		WillReturnError(errors.New("internal error"))
 
@@ -160,7 +163,7 @@ func TestSubscriptionHandlerDuplicateInternalError(t *testing.T) {
 	verifiedRow := mock.NewRows([]string{"acc_verified"}).
 		AddRow(true)
 
-	mock.ExpectQuery("SELECT DISTINCT acc_verified").
+	mock.ExpectQuery("SELECT COALESCE\\(bool_or\\(acc_verified\\), false\\)").
 		WithArgs("d_kokin@inbox.ru").
 		WillReturnRows(verifiedRow)
 
@@ -189,7 +192,7 @@ func TestSubscriptionHandlerSaveSubError(t *testing.T) {
 	verifiedRow := mock.NewRows([]string{"acc_verified"}).
 		AddRow(true)
 
-	mock.ExpectQuery("SELECT DISTINCT acc_verified").
+	mock.ExpectQuery("SELECT COALESCE\\(bool_or\\(acc_verified\\), false\\)").
 		WithArgs("d_kokin@inbox.ru").
 		WillReturnRows(verifiedRow)
 
@@ -201,7 +204,7 @@ func TestSubscriptionHandlerSaveSubError(t *testing.T) {
 		WillReturnRows(notDuplicateRow)
 
 	mock.ExpectExec("INSERT INTO subscription").
-		WithArgs(true, "d_kokin@inbox.ru", 8792009, testServer.URL).
+		WithArgs(true, "d_kokin@inbox.ru", 8792009, testServer.URL, 0, false).
 		WillReturnError(errors.New("internal error"))
 
 	subscriptionHandler := env.SubscriptionHandler
@@ -225,12 +228,16 @@ func TestSubscriptionHandlerStatusOK(t *testing.T) {
 	VerifiedRow := mock.NewRows([]string{"acc_verified"}).
 		AddRow(true)
 
-	mock.ExpectQuery("SELECT DISTINCT acc_verified").
+	mock.ExpectQuery("SELECT COALESCE\\(bool_or\\(acc_verified\\), false\\)").
 		WithArgs("d_kokin@inbox.ru").
 		WillReturnRows(VerifiedRow)
 
 	mock.ExpectExec("INSERT INTO subscription").
-		WithArgs(true, "d_kokin@inbox.ru", 8792009, testServer.URL).
+		WithArgs(true, "d_kokin@inbox.ru", 8792009, testServer.URL, 0, false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectExec("INSERT INTO scrape_job").
+		WithArgs(testServer.URL, false).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	mock.ExpectQuery("SELECT DISTINCT url FROM subscription").
@@ -242,3 +249,119 @@ func TestSubscriptionHandlerStatusOK(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+func TestSubscribeConfirmedSendsNoLetter(t *testing.T) {
+	scp, testServer, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	mock.MatchExpectationsInOrder(false)
+
+	// The email has no confirmed subscriptions yet
+	mock.ExpectQuery("SELECT COALESCE\\(bool_or\\(acc_verified\\), false\\)").
+		WithArgs("d_kokin@inbox.ru").
+		WillReturnRows(mock.NewRows([]string{"acc_verified"}))
+	mock.ExpectQuery("SELECT DISTINCT url FROM subscription").
+		WithArgs("d_kokin@inbox.ru", testServer.URL).
+		WillReturnRows(mock.NewRows([]string{"url"}))
+	mock.ExpectExec("INSERT INTO subscription").
+		WithArgs(true, "d_kokin@inbox.ru", 8792009, testServer.URL, 0, false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO scrape_job").
+		WithArgs(testServer.URL, false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO price_history").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	sub, err := env.SubscribeConfirmed(context.Background(), SubscriptionRequest{Url: testServer.URL, Email: "d_kokin@inbox.ru"})
+	assert.Nil(t, err)
+	assert.True(t, sub.AccVerified)
+	// The letter would be recorded in auth_confirmation
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSecondUnconfirmedSubscriptionStaysUnverified(t *testing.T) {
+	scp, testServer, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	mock.MatchExpectationsInOrder(false)
+
+	// The email has a subscription which is waiting for the confirmation
+	mock.ExpectQuery("SELECT COALESCE\\(bool_or\\(acc_verified\\), false\\)").
+		WithArgs("d_kokin@inbox.ru").
+		WillReturnRows(mock.NewRows([]string{"acc_verified"}).AddRow(false))
+	mock.ExpectQuery("SELECT DISTINCT url FROM subscription").
+		WithArgs("d_kokin@inbox.ru", testServer.URL).
+		WillReturnRows(mock.NewRows([]string{"url"}))
+	mock.ExpectExec("INSERT INTO subscription").
+		WithArgs(false, "d_kokin@inbox.ru", 8792009, testServer.URL, 0, false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO scrape_job").
+		WithArgs(testServer.URL, false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO price_history").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// The confirmation letter is sent again
+	mock.ExpectExec("INSERT INTO auth_confirmation").
+		WillReturnError(errors.New("internal error"))
+
+	sub, err := env.Subscribe(context.Background(), SubscriptionRequest{Url: testServer.URL, Email: "d_kokin@inbox.ru"})
+	assert.NotNil(t, err)
+	assert.False(t, sub.AccVerified)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSubscriptionHandlerLimitsBody(t *testing.T) {
+	scp, _, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+
+	body := `{"url": "https://www.avito.ru/moskva/item_1", "email": "` + strings.Repeat("a", maxBodySize) + `@inbox.ru"}`
+	req := httptest.NewRequest("POST", "/subscribe", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	env.SubscriptionHandler(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestUnsubscribeHandlerRequiresToken(t *testing.T) {
+	scp, testServer, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+
+	// Email alone does not prove that the subscriptions belong to the caller
+	w := httptest.NewRecorder()
+	env.UnsubscribeHandler(w, httptest.NewRequest("POST", "/unsubscribe?url="+testServer.URL+"&email=d_kokin@inbox.ru", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WithArgs("unknown").
+		WillReturnError(sql.ErrNoRows)
+	w = httptest.NewRecorder()
+	env.ListSubscriptionsHandler(w, httptest.NewRequest("GET", "/subscriptions?token=unknown", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestUnsubscribeUsesUrlOfTheAd(t *testing.T) {
+	scp, _, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+
+	// The ad was subscribed by another url, it is found by the id like in the subscription
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WithArgs("feed-token").
+		WillReturnRows(mock.NewRows([]string{"email"}).AddRow("d_kokin@inbox.ru"))
+	mock.ExpectQuery("SELECT url FROM subscription WHERE ad_id").
+		WithArgs(1791027290).
+		WillReturnRows(mock.NewRows([]string{"url"}).AddRow("https://www.avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290"))
+	mock.ExpectExec("DELETE FROM subscription").
+		WithArgs("d_kokin@inbox.ru", "https://www.avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM scrape_job").
+		WithArgs("https://www.avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := env.Unsubscribe(context.Background(), SubscriptionRequest{
+		Url:   "https://m.avito.ru/moskva/bmw_1791027290?utm_source=x",
+		Token: "feed-token",
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
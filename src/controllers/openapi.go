@@ -0,0 +1,613 @@
+package controllers
+
+import (
+	"net/http"
+)
+
+// Handler that returns OpenAPI specification of the service
+func OpenApiHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(openApiSpec))
+}
+
+// OpenAPI 3 description of all endpoints. Routes are also available without the /api/v1 prefix
+const openApiSpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Avito price notification service",
+    "description": "Subscriptions to price changes of avito ads with notifications by email",
+    "version": "1.0.0"
+  },
+  "servers": [
+    {"url": "/api/v1"},
+    {"url": "/", "description": "Legacy routes without version prefix"}
+  ],
+  "paths": {
+    "/subscribe": {
+      "post": {
+        "operationId": "subscribe",
+        "summary": "Subscribe email to price changes of the ad or to new ads of the search results page",
+        "parameters": [
+          {"$ref": "#/components/parameters/UrlQuery"},
+          {"$ref": "#/components/parameters/EmailQuery"}
+        ],
+        "requestBody": {
+          "required": false,
+          "content": {
+            "application/json": {"schema": {"$ref": "#/components/schemas/SubscriptionRequest"}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "Subscription is saved",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Subscription"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/unsubscribe": {
+      "post": {
+        "operationId": "unsubscribe",
+        "summary": "Remove subscription of the owner of the feed token to the ad",
+        "parameters": [
+          {"$ref": "#/components/parameters/UrlQuery"},
+          {"$ref": "#/components/parameters/TokenQuery"}
+        ],
+        "requestBody": {
+          "required": false,
+          "content": {
+            "application/json": {"schema": {"$ref": "#/components/schemas/SubscriptionRequest"}}
+          }
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Status"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/subscriptions": {
+      "get": {
+        "operationId": "listSubscriptions",
+        "summary": "List all subscriptions of the owner of the feed token",
+        "parameters": [
+          {"name": "token", "in": "query", "required": true, "schema": {"type": "string"}, "description": "Secret token of the feed returned by /confirm"}
+        ],
+        "responses": {
+          "200": {
+            "description": "Subscriptions of the owner of the token",
+            "content": {
+              "application/json": {
+                "schema": {"type": "array", "items": {"$ref": "#/components/schemas/Subscription"}}
+              }
+            }
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/confirm": {
+      "get": {
+        "operationId": "confirm",
+        "summary": "Confirm email by the hash from confirmation letter",
+        "parameters": [
+          {"name": "hash", "in": "query", "required": true, "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Email is confirmed or a new letter is sent if the hash has expired",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Confirmation"}}}
+          },
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/history": {
+      "get": {
+        "operationId": "priceHistory",
+        "summary": "Recorded prices of the ad, the oldest first",
+        "parameters": [
+          {"name": "url", "in": "query", "required": true, "schema": {"type": "string", "format": "uri"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Price history",
+            "content": {
+              "application/json": {
+                "schema": {"type": "array", "items": {"$ref": "#/components/schemas/PricePoint"}}
+              }
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/events": {
+      "get": {
+        "operationId": "events",
+        "summary": "Stream of scrapper events as Server-Sent Events",
+        "description": "Event names are price_change, listing_removed, scrape_error, new_listings and scrape_blocked, data is a JSON Event. Comments are sent as heartbeats. The stream without url and token requires the admin token",
+        "security": [{}, {"AdminToken": []}],
+        "parameters": [
+          {"name": "url", "in": "query", "required": false, "schema": {"type": "string", "format": "uri"}},
+          {"$ref": "#/components/parameters/TokenQuery"}
+        ],
+        "responses": {
+          "200": {
+            "description": "Stream of events",
+            "content": {"text/event-stream": {"schema": {"$ref": "#/components/schemas/Event"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/feed/{token}.atom": {
+      "get": {
+        "operationId": "feedAtom",
+        "summary": "Atom feed of the latest price changes of all subscriptions of the user",
+        "parameters": [{"$ref": "#/components/parameters/FeedToken"}],
+        "responses": {
+          "200": {"description": "Atom feed", "content": {"application/atom+xml": {}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/feed/{token}.rss": {
+      "get": {
+        "operationId": "feedRss",
+        "summary": "RSS feed of the latest price changes of all subscriptions of the user",
+        "parameters": [{"$ref": "#/components/parameters/FeedToken"}],
+        "responses": {
+          "200": {"description": "RSS 2.0 feed", "content": {"application/rss+xml": {}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/healthz": {
+      "get": {
+        "operationId": "health",
+        "summary": "Liveness of the service",
+        "description": "Status is degraded if the parser fails on most of the last pages of some host, probably its markup has changed. The status code is 200 anyway",
+        "servers": [{"url": "/"}],
+        "responses": {
+          "200": {
+            "description": "Health of the service and parser states of the hosts",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Health"}}}
+          }
+        }
+      }
+    },
+    "/readyz": {
+      "get": {
+        "operationId": "ready",
+        "summary": "Readiness of the service",
+        "description": "Components are database, migrations, scrapper and smtp. smtp is disabled if the mail account is not set",
+        "servers": [{"url": "/"}],
+        "responses": {
+          "200": {
+            "description": "All components are working",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Readiness"}}}
+          },
+          "503": {
+            "description": "Some component is failed",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Readiness"}}}
+          }
+        }
+      }
+    },
+    "/metrics": {
+      "get": {
+        "operationId": "metrics",
+        "summary": "Metrics of the service in Prometheus text format",
+        "description": "Requests to the API, downloads of ads by host and outcome, price changes, notifications, due checks in the queue and durations of downloads, checks and database queries",
+        "servers": [{"url": "/"}],
+        "responses": {
+          "200": {"description": "Metrics", "content": {"text/plain": {}}}
+        }
+      }
+    },
+    "/admin/listings": {
+      "get": {
+        "operationId": "adminListings",
+        "summary": "State of all ads and searches in the queue of the scrapper",
+        "servers": [{"url": "/"}],
+        "security": [{"AdminToken": []}],
+        "parameters": [
+          {"name": "status", "in": "query", "required": false, "schema": {"type": "string", "enum": ["ok", "failed", "removed", "skipped"]}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Urls with the most failed checks first",
+            "content": {
+              "application/json": {
+                "schema": {"type": "array", "items": {"$ref": "#/components/schemas/ListingStatus"}}
+              }
+            }
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/admin/listings/recheck": {
+      "post": {
+        "operationId": "adminRecheck",
+        "summary": "Check the url by the next free worker",
+        "servers": [{"url": "/"}],
+        "security": [{"AdminToken": []}],
+        "parameters": [{"$ref": "#/components/parameters/UrlQuery"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Status"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/admin/scrapper": {
+      "get": {
+        "operationId": "adminScrapper",
+        "summary": "State of the scrapper of this instance",
+        "servers": [{"url": "/"}],
+        "security": [{"AdminToken": []}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/ScrapperState"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/admin/proxies": {
+      "get": {
+        "operationId": "adminProxies",
+        "summary": "Statistics of the proxies of this instance",
+        "servers": [{"url": "/"}],
+        "security": [{"AdminToken": []}],
+        "responses": {
+          "200": {
+            "description": "Proxies in the order of the config, passwords are hidden",
+            "content": {
+              "application/json": {
+                "schema": {"type": "array", "items": {"$ref": "#/components/schemas/ProxyStats"}}
+              }
+            }
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/admin/scrapper/pause": {
+      "post": {
+        "operationId": "adminPause",
+        "summary": "Stop taking checks from the queue on this instance, current checks are finished",
+        "servers": [{"url": "/"}],
+        "security": [{"AdminToken": []}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/ScrapperState"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/admin/scrapper/resume": {
+      "post": {
+        "operationId": "adminResume",
+        "summary": "Take checks from the queue on this instance again",
+        "servers": [{"url": "/"}],
+        "security": [{"AdminToken": []}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/ScrapperState"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/admin/scrapper/workers": {
+      "post": {
+        "operationId": "adminWorkers",
+        "summary": "Change the number of workers of this instance",
+        "servers": [{"url": "/"}],
+        "security": [{"AdminToken": []}],
+        "parameters": [
+          {"name": "workers", "in": "query", "required": false, "schema": {"type": "integer", "minimum": 1, "maximum": 100}}
+        ],
+        "requestBody": {
+          "required": false,
+          "content": {
+            "application/json": {
+              "schema": {"type": "object", "properties": {"workers": {"type": "integer", "minimum": 1, "maximum": 100}}}
+            }
+          }
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/ScrapperState"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/admin/notifications/failed": {
+      "get": {
+        "operationId": "adminFailedNotifications",
+        "summary": "Notifications which were not delivered",
+        "servers": [{"url": "/"}],
+        "security": [{"AdminToken": []}],
+        "responses": {
+          "200": {
+            "description": "Failed notifications, the oldest first",
+            "content": {
+              "application/json": {
+                "schema": {"type": "array", "items": {"$ref": "#/components/schemas/FailedNotification"}}
+              }
+            }
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/admin/notifications/failed/{id}/resend": {
+      "post": {
+        "operationId": "adminResend",
+        "summary": "Send the failed notification again, it is removed from the list if it is delivered",
+        "servers": [{"url": "/"}],
+        "security": [{"AdminToken": []}],
+        "parameters": [
+          {"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}}
+        ],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Status"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "operationId": "openApi",
+        "summary": "This document",
+        "servers": [{"url": "/"}],
+        "responses": {
+          "200": {"description": "OpenAPI specification", "content": {"application/json": {}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "UrlQuery": {"name": "url", "in": "query", "required": false, "schema": {"type": "string", "format": "uri"}},
+      "EmailQuery": {"name": "email", "in": "query", "required": false, "schema": {"type": "string", "format": "email"}},
+      "TokenQuery": {
+        "name": "token", "in": "query", "required": false, "schema": {"type": "string"},
+        "description": "Secret token of the feed returned by /confirm"
+      },
+      "FeedToken": {
+        "name": "token", "in": "path", "required": true, "schema": {"type": "string"},
+        "description": "Secret token of the feed returned by /confirm"
+      }
+    },
+    "responses": {
+      "Status": {
+        "description": "Operation is done",
+        "content": {
+          "application/json": {
+            "schema": {"type": "object", "properties": {"status": {"type": "string", "example": "ok"}}}
+          }
+        }
+      },
+      "Error": {
+        "description": "Operation failed",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "TooManyRequests": {
+        "description": "Rate limit of the address or the email is exceeded",
+        "headers": {
+          "Retry-After": {"description": "Seconds until the request can be repeated", "schema": {"type": "integer"}}
+        },
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "ScrapperState": {
+        "description": "State of the scrapper of this instance",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ScrapperState"}}}
+      }
+    },
+    "securitySchemes": {
+      "AdminToken": {"type": "http", "scheme": "bearer", "description": "admin_token of the server config"}
+    },
+    "schemas": {
+      "Health": {
+        "type": "object",
+        "required": ["status", "hosts"],
+        "properties": {
+          "status": {"type": "string", "enum": ["ok", "degraded"]},
+          "hosts": {"type": "array", "items": {"$ref": "#/components/schemas/HostParseStatus"}}
+        }
+      },
+      "Readiness": {
+        "type": "object",
+        "required": ["status", "components"],
+        "properties": {
+          "status": {"type": "string", "enum": ["ready", "not_ready"]},
+          "components": {"type": "object", "additionalProperties": {"$ref": "#/components/schemas/ComponentStatus"}}
+        }
+      },
+      "ComponentStatus": {
+        "type": "object",
+        "required": ["status"],
+        "properties": {
+          "status": {"type": "string", "enum": ["ok", "fail", "disabled"]},
+          "error": {"type": "string"}
+        }
+      },
+      "HostParseStatus": {
+        "type": "object",
+        "required": ["host", "checks", "success_ratio", "degraded"],
+        "properties": {
+          "host": {"type": "string"},
+          "checks": {"type": "integer", "description": "Number of the last parsed pages of the host"},
+          "success_ratio": {"type": "number", "format": "double"},
+          "degraded": {"type": "boolean"}
+        }
+      },
+      "SubscriptionRequest": {
+        "type": "object",
+        "properties": {
+          "url": {"type": "string", "format": "uri"},
+          "email": {"type": "string", "format": "email"},
+          "token": {"type": "string", "description": "Secret token of the feed, it is required to remove the subscription"}
+        }
+      },
+      "Subscription": {
+        "type": "object",
+        "properties": {
+          "acc_verified": {"type": "boolean"},
+          "email": {"type": "string", "format": "email"},
+          "price": {"type": "integer"},
+          "url": {"type": "string", "format": "uri"},
+          "ad_id": {"type": "integer", "format": "int64"},
+          "is_search": {"type": "boolean", "description": "Subscription to new ads of the search results page"},
+          "listing": {"$ref": "#/components/schemas/ListingInfo"}
+        }
+      },
+      "ListingInfo": {
+        "type": "object",
+        "description": "Description of the ad taken from its page, absent if it is not known",
+        "properties": {
+          "title": {"type": "string"},
+          "image_url": {"type": "string", "format": "uri"},
+          "location": {"type": "string"},
+          "seller": {"type": "string"},
+          "published_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Confirmation": {
+        "type": "object",
+        "properties": {
+          "status": {"type": "string", "example": "ok"},
+          "feed_atom": {"type": "string", "description": "Path of the user's Atom feed, only if the email is confirmed"},
+          "feed_rss": {"type": "string", "description": "Path of the user's RSS feed, only if the email is confirmed"}
+        }
+      },
+      "PricePoint": {
+        "type": "object",
+        "properties": {
+          "url": {"type": "string", "format": "uri"},
+          "price": {"type": "integer"},
+          "checked_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Listing": {
+        "type": "object",
+        "properties": {
+          "ad_id": {"type": "integer", "format": "int64"},
+          "url": {"type": "string", "format": "uri"},
+          "price": {"type": "integer"}
+        }
+      },
+      "Event": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer", "format": "int64"},
+          "kind": {"type": "string", "enum": ["price_change", "listing_removed", "scrape_error", "new_listings", "scrape_blocked"]},
+          "url": {"type": "string", "format": "uri"},
+          "old_price": {"type": "integer"},
+          "new_price": {"type": "integer"},
+          "error": {"type": "string"},
+          "listings": {"type": "array", "items": {"$ref": "#/components/schemas/Listing"}},
+          "time": {"type": "string", "format": "date-time"}
+        }
+      },
+      "ListingStatus": {
+        "type": "object",
+        "required": ["url", "is_search", "subscribers", "next_check_at", "failures"],
+        "properties": {
+          "url": {"type": "string", "format": "uri"},
+          "is_search": {"type": "boolean"},
+          "subscribers": {"type": "integer"},
+          "status": {"type": "string", "enum": ["ok", "failed", "removed", "skipped"], "description": "Result of the last check, absent before the first check"},
+          "last_error": {"type": "string"},
+          "last_check_at": {"type": "string", "format": "date-time"},
+          "next_check_at": {"type": "string", "format": "date-time"},
+          "failures": {"type": "integer", "description": "Failed checks in a row"},
+          "leased_by": {"type": "string", "description": "Instance which checks the url right now"}
+        }
+      },
+      "ScrapperState": {
+        "type": "object",
+        "required": ["running", "paused", "workers"],
+        "properties": {
+          "running": {"type": "boolean"},
+          "paused": {"type": "boolean"},
+          "workers": {"type": "integer"}
+        }
+      },
+      "ProxyStats": {
+        "type": "object",
+        "required": ["url", "successes", "failures", "evicted"],
+        "properties": {
+          "url": {"type": "string"},
+          "successes": {"type": "integer", "format": "int64"},
+          "failures": {"type": "integer", "format": "int64"},
+          "evicted": {"type": "boolean"},
+          "evicted_till": {"type": "string", "format": "date-time"}
+        }
+      },
+      "FailedNotification": {
+        "type": "object",
+        "required": ["id", "channel", "email", "error", "attempts", "failed_at"],
+        "properties": {
+          "id": {"type": "integer", "format": "int64"},
+          "channel": {"type": "string", "enum": ["email", "alert"]},
+          "email": {"type": "string", "format": "email"},
+          "url": {"type": "string", "format": "uri"},
+          "error": {"type": "string"},
+          "attempts": {"type": "integer"},
+          "failed_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["code", "message"],
+        "properties": {
+          "code": {
+            "type": "string",
+            "enum": [
+              "invalid_body", "invalid_url", "invalid_email", "listing_unreachable",
+              "duplicate_subscription", "subscription_not_found", "confirmation_not_found", "feed_not_found",
+              "not_found", "method_not_allowed", "internal_error", "temporarily_unavailable",
+              "unauthorized", "forbidden", "listing_not_found", "notification_not_found", "invalid_workers",
+              "too_many_requests", "subscription_limit", "host_not_allowed"
+            ]
+          },
+          "message": {"type": "string"},
+          "field": {"type": "string"}
+        }
+      }
+    }
+  }
+}
+`
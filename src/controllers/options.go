@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"test_avito/config"
+	"test_avito/src/services"
+)
+
+// Options of the scrapper taken from the config. They are replaced as a whole when the config is reloaded,
+// so a check never sees a half of the old options and a half of the new ones
+type scrapperOptions struct {
+	minInterval   time.Duration
+	maxInterval   time.Duration
+	pageTimeout   time.Duration
+	maxPageSize   int64
+	operatorEmail string
+}
+
+// Function that takes the options from the config. Without the bounds all ads are checked with the same timeout
+func newScrapperOptions(cnf config.Scrapper) *scrapperOptions {
+	scrapperTimeout := time.Minute * time.Duration(cnf.ScrapperTimeout)
+	minInterval := time.Minute * time.Duration(cnf.MinInterval)
+	if cnf.MinInterval == 0 {
+		minInterval = scrapperTimeout
+	}
+	maxInterval := time.Minute * time.Duration(cnf.MaxInterval)
+	if cnf.MaxInterval == 0 {
+		maxInterval = scrapperTimeout
+	}
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+
+	return &scrapperOptions{
+		minInterval:   minInterval,
+		maxInterval:   maxInterval,
+		pageTimeout:   time.Duration(cnf.PageDownloadingTimeout) * time.Millisecond,
+		maxPageSize:   cnf.MaxPageSize << 10,
+		operatorEmail: cnf.OperatorEmail,
+	}
+}
+
+func storeOptions(options *scrapperOptions) *atomic.Pointer[scrapperOptions] {
+	var pointer atomic.Pointer[scrapperOptions]
+	pointer.Store(options)
+	return &pointer
+}
+
+// Current options of the scrapper
+func (scp *Scrapper) opts() *scrapperOptions {
+	if scp.options == nil {
+		return &scrapperOptions{}
+	}
+	return scp.options.Load()
+}
+
+// Wait of the check for the page when the page timeout is not set
+const defaultPageWait = 3 * time.Second
+
+// Time the check waits for the page, it follows the page timeout of the reloaded config
+func (scp *Scrapper) pageWait() time.Duration {
+	timeout := scp.opts().pageTimeout
+	if timeout <= 0 {
+		return defaultPageWait
+	}
+	return timeout
+}
+
+// Function that applies the reloaded config to the running scrapper. Checks in progress finish
+// with the old options. Proxies are used only on the start
+func (scp *Scrapper) Reconfigure(cnf config.Config) {
+	scp.options.Store(newScrapperOptions(cnf.Scrapper))
+	scp.canary.configure(cnf.CanaryWindow, cnf.CanaryThreshold)
+	scp.workers.setCountOrInitial(cnf.WorkerCount)
+}
+
+// Body of the response that releases the timeout of the request when it is closed
+type timeoutBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b timeoutBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Function that sends the request with the page timeout of the current options.
+// Like http.Client.Timeout the timeout includes reading of the body
+func (scp *Scrapper) send(req *http.Request) (*http.Response, error) {
+	timeout := scp.opts().pageTimeout
+	if timeout <= 0 {
+		return scp.Client.Do(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := scp.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = timeoutBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// Function that applies the reloaded config to the running service without the restart of the servers.
+// The database, the ports, the admin token, the tracing and the proxies are used only on the start
+func (env *EnvironmentNotification) Reconfigure(cnf config.Config) {
+	env.Scp.Reconfigure(cnf)
+	env.Limits.configure(cnf.Limits)
+	services.SetMailAccount(cnf.Mail)
+}
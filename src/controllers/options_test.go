@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+)
+
+func TestReconfigureReplacesOptions(t *testing.T) {
+	cnf := config.Default()
+	scp := NewScrapper(nil, cnf)
+	scp.canary.record("www.avito.ru", false)
+
+	cnf.MinInterval = 2
+	cnf.MaxInterval = 60
+	cnf.PageDownloadingTimeout = 500
+	cnf.OperatorEmail = "operator@example.com"
+	cnf.CanaryWindow = 50
+	cnf.WorkerCount = 4
+	scp.Reconfigure(cnf)
+
+	options := scp.opts()
+	assert.Equal(t, 2*time.Minute, options.minInterval)
+	assert.Equal(t, time.Hour, options.maxInterval)
+	assert.Equal(t, 500*time.Millisecond, options.pageTimeout)
+	assert.Equal(t, "operator@example.com", options.operatorEmail)
+	// Results of the old window are forgotten
+	assert.Equal(t, 50, scp.canary.window)
+	assert.Empty(t, scp.canary.hosts)
+	// The pool is not started yet, the number is used on the start
+	assert.Equal(t, 4, scp.workers.initial)
+}
+
+func TestPageTimeoutIsTakenFromOptions(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	scp := Scrapper{Client: slow.Client(), options: storeOptions(&scrapperOptions{})}
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", slow.URL, nil)
+
+	scp.options.Store(&scrapperOptions{pageTimeout: 50 * time.Millisecond})
+	_, err := scp.send(req)
+	assert.NotNil(t, err)
+
+	scp.options.Store(&scrapperOptions{pageTimeout: 5 * time.Second})
+	resp, err := scp.send(req)
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+	}
+}
+
+func TestCheckWaitsForPageTimeout(t *testing.T) {
+	scp := Scrapper{options: storeOptions(&scrapperOptions{})}
+	assert.Equal(t, defaultPageWait, scp.pageWait())
+
+	// The larger timeout of the reloaded config is waited by the check too
+	scp.options.Store(&scrapperOptions{pageTimeout: 10 * time.Second})
+	assert.Equal(t, 10*time.Second, scp.pageWait())
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"test_avito/src/logging"
+	"test_avito/src/tracing"
+)
+
+// Header with the id of the request. The id of the client is kept if it is short and safe for logs
+const requestIdHeader = "X-Request-Id"
+
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Middleware that gives every request an id. The id is returned in the response header
+// and is attached to every log line written while the request is handled
+func requestIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if !validRequestId.MatchString(id) {
+			id = logging.NewRequestId()
+		}
+		w.Header().Set(requestIdHeader, id)
+
+		logger := slog.Default().With("request_id", id)
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+			logger = logger.With("trace_id", spanContext.TraceID().String())
+		}
+		r = r.WithContext(logging.WithLogger(r.Context(), logger))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		started := time.Now()
+		next.ServeHTTP(rec, r)
+
+		logger.Info("request", "method", r.Method, "path", r.URL.Path, "status", rec.status,
+			"duration_ms", time.Since(started).Milliseconds())
+	})
+}
+
+// Middleware that starts the span of every request, it is the parent of the spans of the handler
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		ctx, span := tracing.StartRequest(r, r.Method+" "+route,
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
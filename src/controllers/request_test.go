@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/src/logging"
+)
+
+func captureLogs(t *testing.T, format string) *bytes.Buffer {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "debug", format)
+	assert.Nil(t, err)
+	old := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return &buf
+}
+
+func TestRequestIdIsReturnedAndLogged(t *testing.T) {
+	logs := captureLogs(t, logging.FormatJSON)
+	scp, _, _ := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+
+	w := httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	id := w.Header().Get(requestIdHeader)
+	assert.Len(t, id, 16)
+
+	var line map[string]interface{}
+	assert.Nil(t, json.Unmarshal(logs.Bytes(), &line))
+	assert.Equal(t, id, line["request_id"])
+	assert.Equal(t, "/healthz", line["path"])
+	assert.Equal(t, float64(200), line["status"])
+}
+
+func TestRequestIdOfClientIsKept(t *testing.T) {
+	logs := captureLogs(t, logging.FormatLogfmt)
+	scp, _, _ := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+
+	req := httptest.NewRequest("POST", "/api/v1/unsubscribe?email=bad", nil)
+	req.Header.Set(requestIdHeader, "client-id-1")
+	w := httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, req)
+	assert.Equal(t, "client-id-1", w.Header().Get(requestIdHeader))
+	assert.Contains(t, logs.String(), "request_id=client-id-1")
+
+	// Ids which can break the log lines are replaced
+	req = httptest.NewRequest("GET", "/healthz", nil)
+	req.Header.Set(requestIdHeader, "bad id\nlevel=ERROR")
+	w = httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, req)
+	assert.False(t, strings.Contains(w.Header().Get(requestIdHeader), " "))
+}
+
+func TestLoggingOptions(t *testing.T) {
+	_, err := logging.New(&bytes.Buffer{}, "verbose", logging.FormatJSON)
+	assert.NotNil(t, err)
+	_, err = logging.New(&bytes.Buffer{}, "info", "xml")
+	assert.NotNil(t, err)
+
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "warn", logging.FormatLogfmt)
+	assert.Nil(t, err)
+	logger.Info("hidden")
+	logger.Warn("shown", "url", "https://www.avito.ru/item_1")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), `level=WARN msg=shown url=https://www.avito.ru/item_1`)
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// Machine readable error codes returned to the clients
+const (
+	ErrInvalidBody            = "invalid_body"
+	ErrInvalidUrl             = "invalid_url"
+	ErrInvalidEmail           = "invalid_email"
+	ErrListingUnreachable     = "listing_unreachable"
+	ErrDuplicateSubscription  = "duplicate_subscription"
+	ErrSubscriptionNotFound   = "subscription_not_found"
+	ErrConfirmationNotFound   = "confirmation_not_found"
+	ErrFeedNotFound           = "feed_not_found"
+	ErrNotFound               = "not_found"
+	ErrMethodNotAllowed       = "method_not_allowed"
+	ErrInternal               = "internal_error"
+	ErrTemporarilyUnavailable = "temporarily_unavailable"
+	ErrUnauthorized           = "unauthorized"
+	ErrForbidden              = "forbidden"
+	ErrListingNotFound        = "listing_not_found"
+	ErrNotificationNotFound   = "notification_not_found"
+	ErrInvalidWorkers         = "invalid_workers"
+	ErrTooManyRequests        = "too_many_requests"
+	ErrSubscriptionLimit      = "subscription_limit"
+	ErrHostNotAllowed         = "host_not_allowed"
+)
+
+// Body of every unsuccessful response. Status is the http status of the response
+type ApiError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+	// Seconds until the request can be repeated, it is sent in Retry-After header
+	RetryAfter int `json:"-"`
+}
+
+func (e *ApiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newApiError(status int, code string, message string, field string) *ApiError {
+	return &ApiError{
+		Status:  status,
+		Code:    code,
+		Message: message,
+		Field:   field,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// Function that writes the error to the response. Errors which are not ApiError are hidden from the client
+func writeError(w http.ResponseWriter, err error) {
+	apiErr, ok := err.(*ApiError)
+	if !ok {
+		apiErr = newApiError(http.StatusInternalServerError, ErrInternal, "internal error", "")
+	}
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(apiErr.RetryAfter))
+	}
+	writeJSON(w, apiErr.Status, apiErr)
+}
@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const apiPrefix = "/api/v1"
+
+// Creating the router of the service. Routes without the version prefix are kept for old clients
+func NewRouter(env *EnvironmentNotification) *mux.Router {
+	r := mux.NewRouter()
+
+	api := r.PathPrefix(apiPrefix).Subrouter()
+	registerRoutes(api, env)
+	registerRoutes(r, env)
+	r.HandleFunc("/openapi.json", OpenApiHandler).Methods("GET")
+	r.HandleFunc("/healthz", env.HealthHandler).Methods("GET")
+	r.HandleFunc("/readyz", env.ReadyHandler).Methods("GET")
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	registerAdminRoutes(r, env)
+	r.Use(tracingMiddleware, requestIdMiddleware, metricsMiddleware)
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, newApiError(http.StatusNotFound, ErrNotFound, "route is not found", ""))
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, newApiError(http.StatusMethodNotAllowed, ErrMethodNotAllowed, "method is not allowed", ""))
+	})
+	return r
+}
+
+func registerRoutes(r *mux.Router, env *EnvironmentNotification) {
+	r.HandleFunc("/subscribe", env.SubscriptionHandler).Methods("POST")
+	r.HandleFunc("/confirm", env.ConfirmEmailHandler).Methods("GET")
+	r.HandleFunc("/unsubscribe", env.UnsubscribeHandler).Methods("POST")
+	r.HandleFunc("/subscriptions", env.ListSubscriptionsHandler).Methods("GET")
+	r.HandleFunc("/history", env.PriceHistoryHandler).Methods("GET")
+	r.HandleFunc("/events", env.EventsHandler).Methods("GET")
+	r.HandleFunc("/feed/{token}.atom", env.FeedHandler("atom")).Methods("GET")
+	r.HandleFunc("/feed/{token}.rss", env.FeedHandler("rss")).Methods("GET")
+}
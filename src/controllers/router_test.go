@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeApiError(t *testing.T, w *httptest.ResponseRecorder) ApiError {
+	var apiErr ApiError
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&apiErr))
+	return apiErr
+}
+
+func TestRouterJSONBodyInvalidEmail(t *testing.T) {
+	scp, testServer, _ := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	router := NewRouter(&env)
+
+	body := strings.NewReader(`{"url": "` + testServer.URL + `", "email": "badEmail"}`)
+	req, err := http.NewRequest("POST", "http://localhost/api/v1/subscribe", body)
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	apiErr := decodeApiError(t, w)
+	assert.Equal(t, ErrInvalidEmail, apiErr.Code)
+	assert.Equal(t, "email", apiErr.Field)
+}
+
+func TestRouterInvalidJSONBody(t *testing.T) {
+	scp, _, _ := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	router := NewRouter(&env)
+
+	req, err := http.NewRequest("POST", "http://localhost/api/v1/subscribe", strings.NewReader("{"))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrInvalidBody, decodeApiError(t, w).Code)
+}
+
+func TestRouterLegacyRouteUnreachableListing(t *testing.T) {
+	scp, _, _ := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	router := NewRouter(&env)
+
+	req, err := http.NewRequest("POST", "http://localhost/subscribe?url=vk.com&email=d_kokin@inbox.ru", nil)
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	apiErr := decodeApiError(t, w)
+	assert.Equal(t, ErrListingUnreachable, apiErr.Code)
+	assert.Equal(t, "url", apiErr.Field)
+}
+
+func TestRouterConfirmUnknownHash(t *testing.T) {
+	scp, _, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	router := NewRouter(&env)
+
+	mock.ExpectQuery("SELECT \\* FROM auth_confirmation").
+		WithArgs("unknown").
+		WillReturnRows(sqlmock.NewRows([]string{"email", "hash", "deadline"}))
+
+	req, err := http.NewRequest("GET", "http://localhost/api/v1/confirm?hash=unknown", nil)
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ErrConfirmationNotFound, decodeApiError(t, w).Code)
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	scp, _, _ := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	router := NewRouter(&env)
+
+	req, err := http.NewRequest("GET", "http://localhost/api/v1/subscribe", nil)
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, ErrMethodNotAllowed, decodeApiError(t, w).Code)
+}
+
+func TestOpenApiDescribesAllRoutes(t *testing.T) {
+	scp, _, _ := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	router := NewRouter(&env)
+
+	req, err := http.NewRequest("GET", "http://localhost/openapi.json", nil)
+	assert.Nil(t, err)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var spec struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&spec))
+
+	err = router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || strings.HasPrefix(path, apiPrefix) {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		operations, ok := spec.Paths[path]
+		assert.True(t, ok, path)
+		for _, method := range methods {
+			assert.Contains(t, operations, strings.ToLower(method), path)
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	"time"
+
+	"test_avito/config"
+)
+
+// Function that chooses the pause before the next check of the ad. Dormant ads with one subscriber
+// are checked every maxInterval. The pause is divided by 1 + 2 * active days of the last month
+// + subscribers after the first one, but it is never less than minInterval
+func (scp *Scrapper) nextCheckInterval(pair config.CheckPriceRequest) time.Duration {
+	weight := 1.0 + 2.0*float64(pair.ActiveDays)
+	if pair.Subscribers > 1 {
+		weight += float64(pair.Subscribers - 1)
+	}
+
+	options := scp.opts()
+	interval := time.Duration(float64(options.maxInterval) / weight)
+	if interval < options.minInterval {
+		interval = options.minInterval
+	}
+	return interval
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+)
+
+func TestNextCheckInterval(t *testing.T) {
+	scp := Scrapper{options: storeOptions(&scrapperOptions{minInterval: time.Minute, maxInterval: 6 * time.Hour})}
+
+	tests := []struct {
+		pair     config.CheckPriceRequest
+		interval time.Duration
+	}{
+		{config.CheckPriceRequest{Subscribers: 1}, 6 * time.Hour},
+		{config.CheckPriceRequest{Subscribers: 0}, 6 * time.Hour},
+		{config.CheckPriceRequest{Subscribers: 1, ActiveDays: 1}, 2 * time.Hour},
+		{config.CheckPriceRequest{Subscribers: 4, ActiveDays: 1}, time.Hour},
+		{config.CheckPriceRequest{Subscribers: 1, ActiveDays: 30}, 6 * time.Hour / 61},
+		{config.CheckPriceRequest{Subscribers: 1000, ActiveDays: 30}, time.Minute},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.interval, scp.nextCheckInterval(test.pair), "%+v", test.pair)
+	}
+}
+
+func TestNewScrapperIntervals(t *testing.T) {
+	scp := NewScrapper(nil, config.Config{Scrapper: config.Scrapper{ScrapperTimeout: 5}})
+	assert.Equal(t, 5*time.Minute, scp.opts().minInterval)
+	assert.Equal(t, 5*time.Minute, scp.opts().maxInterval)
+
+	scp = NewScrapper(nil, config.Config{Scrapper: config.Scrapper{ScrapperTimeout: 5, MinInterval: 30, MaxInterval: 10}})
+	assert.Equal(t, 30*time.Minute, scp.opts().minInterval)
+	assert.Equal(t, 30*time.Minute, scp.opts().maxInterval)
+}
@@ -1,157 +1,521 @@
 package controllers
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"log/slog"
+	"net"
 	"net/http"
+	neturl "net/url"
+	"os"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"test_avito/config"
+	"test_avito/src/metrics"
 	"test_avito/src/services"
+	"test_avito/src/tracing"
+	"test_avito/utils"
 )
 
+// Avito answers with 404 or 410 for ads that were removed by the seller
+var errListingRemoved = errors.New("listing is removed")
+
+// Workers can be changed only while the scrapper is running
+var errScrapperNotRunning = errors.New("scrapper is not running")
+
 type Scrapper struct {
 	Db          *services.DB
 	Client      *http.Client
 	WorkerCount int
+	Events      *Broker
+	Proxies     *ProxyPool
 
-	scrapperTimeout time.Duration
-	requestTimeout  time.Duration
-	pairChannel     chan config.CheckPriceRequest
+	options    *atomic.Pointer[scrapperOptions]
+	cache      *fetchCache
+	cooldowns  *hostCooldowns
+	canary     *parseCanary
+	activity   *scrapperActivity
+	workers    *workerPool
+	instanceId string
+	stop       chan struct{}
+}
+
+// Pause of the worker when there are no due checks in the queue
+var queuePollInterval = time.Second
+
+// Pause between heartbeats of the instance, a few of them can be lost before other instances take its checks
+var instanceHeartbeatInterval = services.InstanceTimeout / 3
+
+// How often the running check renews its lease
+var leaseRenewInterval = services.LeaseRenewInterval
+
+// Id of the instance is unique among all replicas of the service, also after restarts
+func newInstanceId() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "scrapper"
+	}
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
 }
 
 // Creating a new scrapper according to the config
 func NewScrapper(db *services.DB, cnf config.Config) Scrapper {
 	tr := &http.Transport{
+		Proxy:       proxyFromContext,
+		DialContext: safeDialContext(),
 		TLSClientConfig: &tls.Config{
 			MaxVersion: tls.VersionTLS12,
 		},
 	}
 
+	// The page timeout is taken from the options for every request, so it can be changed by the reload
 	client := &http.Client{
-		Transport: tr,
-		Timeout:   time.Duration(cnf.Scrapper.PageDownloadingTimeout) * time.Millisecond,
+		Transport:     safeTransport{base: tr, resolver: net.DefaultResolver},
+		CheckRedirect: checkRedirect,
 	}
 
-	return Scrapper{
-		Db:              db,
-		Client:          client,
-		scrapperTimeout: time.Minute * time.Duration(cnf.ScrapperTimeout),
-		WorkerCount:     cnf.WorkerCount,
-		pairChannel:     make(chan config.CheckPriceRequest, 512),
+	scp := Scrapper{
+		Db:          db,
+		Client:      client,
+		options:     storeOptions(newScrapperOptions(cnf.Scrapper)),
+		cache:       newFetchCache(),
+		cooldowns:   newHostCooldowns(),
+		canary:      newParseCanary(cnf.CanaryWindow, cnf.CanaryThreshold),
+		activity:    &scrapperActivity{},
+		workers:     newWorkerPool(),
+		WorkerCount: cnf.WorkerCount,
+		Events:      NewBroker(),
+		Proxies:     NewProxyPool(cnf.Proxies, cnf.ProxyHealthUrl),
+		instanceId:  newInstanceId(),
+		stop:        make(chan struct{}),
 	}
+	scp.canary.alert = scp.alertParserDrift
+	return scp
 }
 
-// Function that starts the scrapper
+// Function that starts the scrapper. Workers take due checks from the queue in the database
+// one by one, so the checks of all ads are spread over the timeout instead of one burst.
+// Several instances of the service share the queue, each check is leased to one of them
 func (scp *Scrapper) Start() {
-	var wg sync.WaitGroup
+	ctx := context.Background()
+	err := scp.Db.Heartbeat(ctx, scp.instanceId)
+	if err != nil {
+		slog.Error("Couldn't register the scrapper instance", "instance", scp.instanceId, "error", err)
+	}
+	go scp.sendHeartbeats()
+
+	if !scp.Proxies.empty() {
+		go scp.checkProxies(proxyCheckInterval)
+	}
+
+	scp.activity.setRunning(true)
+	scp.workers.start(scp.WorkerCount, scp.stop, scp.startWorker)
+	scp.activity.setRunning(false)
+
+	err = scp.Db.DeregisterInstance(ctx, scp.instanceId)
+	if err != nil {
+		slog.Error("Couldn't deregister the scrapper instance", "instance", scp.instanceId, "error", err)
+	}
+}
+
+// Function that tells other instances that this one is alive, so they do not take its leased checks
+func (scp *Scrapper) sendHeartbeats() {
+	ticker := time.NewTicker(instanceHeartbeatInterval)
+	defer ticker.Stop()
 	for {
-		// Launching workers in different goroutines
-		for i := 0; i < scp.WorkerCount; i++ {
-			wg.Add(1)
-			go scp.startWorker(&wg)
+		select {
+		case <-scp.stop:
+			return
+		case <-ticker.C:
+			err := scp.Db.Heartbeat(context.Background(), scp.instanceId)
+			if err != nil {
+				slog.Error("Couldn't send heartbeat of the scrapper instance", "instance", scp.instanceId, "error", err)
+			}
+			scp.updateQueueDepth()
 		}
+	}
+}
 
-		// Getting unique urls and prices from database for to transfer them to the workers
-		err := scp.Db.GetAllUniqueUrlsAndPrices(scp.pairChannel)
-		if err != nil {
-			fmt.Println("Couldn't get links to ads")
-		}
-		close(scp.pairChannel)
+// Function that updates the gauge of due checks. All instances show the same number of the shared queue
+func (scp *Scrapper) updateQueueDepth() {
+	count, err := scp.Db.CountDueChecks(context.Background())
+	if err != nil {
+		slog.Error("Couldn't count due checks", "error", err)
+		return
+	}
+	metrics.QueueDepth.Set(float64(count))
+}
 
-		wg.Wait()
-		time.Sleep(scp.scrapperTimeout)
-		scp.pairChannel = make(chan config.CheckPriceRequest, 512)
+// Function that stops workers of the scrapper after their current checks
+func (scp *Scrapper) Stop() {
+	select {
+	case <-scp.stop:
+	default:
+		close(scp.stop)
 	}
 }
 
-func (scp *Scrapper) startWorker(wg *sync.WaitGroup) {
-	defer wg.Done()
-	for pair := range scp.pairChannel {
-		// Getting price from avito website
-		chanPrice := make(chan config.GetPriceResponse, 1)
-		go scp.getPrice(pair.Url, chanPrice)
-		var productPrice int
+// Function that stops the scrapper until Resume is called. Workers finish their current checks
+func (scp *Scrapper) Pause() {
+	scp.workers.setPaused(true)
+}
+
+func (scp *Scrapper) Resume() {
+	scp.workers.setPaused(false)
+}
+
+func (scp *Scrapper) Paused() bool {
+	return scp.workers.isPaused()
+}
+
+// Number of workers of the running scrapper
+func (scp *Scrapper) Workers() int {
+	return scp.workers.count()
+}
+
+// Function that changes the number of workers of the running scrapper
+func (scp *Scrapper) SetWorkers(count int) error {
+	if !scp.workers.setCount(count) {
+		return errScrapperNotRunning
+	}
+	return nil
+}
+
+// Worker takes checks from the queue until the scrapper is stopped or the worker is removed by quit
+func (scp *Scrapper) startWorker(quit <-chan struct{}) {
+	// False if the worker has to stop
+	sleep := func(pause time.Duration) bool {
 		select {
-		case value := <-chanPrice:
-			if value.Error != nil {
-				fmt.Printf("Error %s", value.Error)
-				continue
-			} else {
-				productPrice = value.Price
+		case <-scp.stop:
+			return false
+		case <-quit:
+			return false
+		case <-time.After(pause):
+			return true
+		}
+	}
+
+	for {
+		select {
+		case <-scp.stop:
+			return
+		case <-quit:
+			return
+		default:
+		}
+		scp.activity.touch()
+
+		// The operator has paused the scrapper, checks are not taken until the pause is over,
+		// so they stay in the queue for other instances. The worker still shows that it is alive
+		if scp.workers.isPaused() {
+			if !sleep(queuePollInterval) {
+				return
 			}
-		case <-time.After(time.Millisecond * 3000):
-			fmt.Printf("Link: %s is not available. Timeout", pair.Url)
 			continue
 		}
 
-		if productPrice != pair.OldPrice {
-			// Getting all subscribers for an ad that has changed its price
-			subs, err := scp.Db.GetEmailsByUrl(pair.Url)
-			if err != nil {
-				fmt.Printf("Internal error, trying to get emails by url:%s", pair.Url)
-				continue
+		// The check is leased to this instance, so other workers skip it
+		pair, found, err := scp.Db.ClaimDueCheck(context.Background(), scp.instanceId)
+		if err != nil {
+			slog.Error("Couldn't get links to ads", "error", err)
+		}
+		if err != nil || !found {
+			if !sleep(queuePollInterval) {
+				return
 			}
+			continue
+		}
 
-			// Sending a message about price changes
-			scp.Db.SendMessages(subs)
-			for _, value := range subs {
-				value.Price = productPrice
-				scp.Db.UpdateSubscription(value)
+		// Avito has blocked the crawler on the host of the url, the check is put off until the pause
+		// of the host is over. Checks of other hosts go on
+		if left := scp.cooldowns.remaining(hostOf(pair.Url)); left > 0 {
+			err = scp.Db.CompleteCheck(context.Background(), pair.Url, scp.instanceId, left,
+				config.CheckResult{Status: config.CheckSkipped, Error: errHostCooldown.Error()})
+			if err != nil {
+				slog.Error("Couldn't put off the check", "url", pair.Url, "error", err)
 			}
+			continue
+		}
+
+		started := time.Now()
+		stopRenewal := scp.keepLease(pair.Url)
+		result := scp.checkPair(pair)
+		stopRenewal()
+		metrics.CheckDuration.Observe(time.Since(started).Seconds())
+
+		err = scp.Db.CompleteCheck(context.Background(), pair.Url, scp.instanceId, scp.nextCheckInterval(pair), result)
+		if err != nil {
+			slog.Error("Couldn't complete the check", "url", pair.Url, "error", err)
 		}
 	}
 }
 
-func (scp *Scrapper) getPrice(url string, priceChan chan config.GetPriceResponse) {
-	defer close(priceChan)
-	response := config.GetPriceResponse{
-		Price: -1,
-		Error: nil,
+// Function that renews the lease of the check until the returned function is called. The check which
+// notifies many subscribers can last longer than the lease, without the renewal another instance would
+// take it and notify them again
+func (scp *Scrapper) keepLease(url string) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(leaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				renewed, err := scp.Db.RenewLease(context.Background(), url, scp.instanceId)
+				if err != nil {
+					slog.Error("Couldn't renew the lease of the check", "url", url, "error", err)
+				} else if !renewed {
+					slog.Warn("Lease of the check is taken by another instance", "url", url)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Function that checks one ad or search and notifies subscribers about changes
+func (scp *Scrapper) checkPair(pair config.CheckPriceRequest) config.CheckResult {
+	logger := slog.With("url", pair.Url, "is_search", pair.IsSearch)
+	ctx, span := tracing.Start(context.Background(), "checkPair",
+		attribute.String("url.full", pair.Url), attribute.Bool("is_search", pair.IsSearch))
+	defer span.End()
+	// The download which is late is stopped when the check is over
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Getting price from avito website
+	chanPrice := make(chan config.GetPriceResponse, 1)
+	go scp.getPrice(ctx, pair.Url, chanPrice)
+	var productPrice int
+	var listings []config.Listing
+	var info config.ListingInfo
+	select {
+	case value := <-chanPrice:
+		if value.Error == nil && value.IsSearch != pair.IsSearch {
+			value.Error = errors.New("type of the page has changed")
+		}
+		if value.Error != nil {
+			if value.Error == errBlocked || value.Error == errHostCooldown {
+				// The block is already reported, the ad is checked again later
+				logger.Debug("Check is skipped", "error", value.Error)
+				return config.CheckResult{Status: config.CheckSkipped, Error: value.Error.Error()}
+			}
+			logger.Warn("Price is not received", "error", value.Error)
+			if value.Error == errListingRemoved {
+				scp.publishEvent(ctx, config.Event{Kind: config.EventListingRemoved, Url: pair.Url}, nil)
+				return config.CheckResult{Status: config.CheckRemoved, Error: value.Error.Error()}
+			}
+			scp.publishEvent(ctx, config.Event{Kind: config.EventScrapeError, Url: pair.Url, Error: value.Error.Error()}, nil)
+			return config.CheckResult{Status: config.CheckFailed, Error: value.Error.Error()}
+		} else {
+			productPrice = value.Price
+			listings = value.Listings
+			info = value.Info
+		}
+	case <-time.After(scp.pageWait()):
+		logger.Warn("Link is not available", "error", "timeout")
+		scp.publishEvent(ctx, config.Event{Kind: config.EventScrapeError, Url: pair.Url, Error: "timeout"}, nil)
+		return config.CheckResult{Status: config.CheckFailed, Error: "timeout"}
 	}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		response.Error = err
-		priceChan <- response
+
+	if pair.IsSearch {
+		scp.checkNewListings(ctx, pair.Url, listings)
+		return config.CheckResult{Status: config.CheckOk}
+	}
+
+	// Description of the ad is refreshed on every check, the title or the photo can be changed by the seller
+	if !isEmptyInfo(info) {
+		err := scp.Db.SaveListingInfo(ctx, pair.Url, info)
+		if err != nil {
+			logger.Error("Couldn't save description of the ad", "error", err)
+		}
+	}
+
+	if productPrice != pair.OldPrice {
+		metrics.PriceChanges.Inc()
+		logger.Info("Price has changed", "old_price", pair.OldPrice, "new_price", productPrice)
+
+		// Getting all subscribers for an ad that has changed its price
+		subs, err := scp.Db.GetEmailsByUrl(ctx, pair.Url)
+		if err != nil {
+			logger.Error("Couldn't get emails of subscribers", "error", err)
+			return config.CheckResult{Status: config.CheckFailed, Error: err.Error()}
+		}
+
+		// Sending a message about price changes
+		if !isEmptyInfo(info) {
+			for i := range subs {
+				subs[i].Info = &info
+			}
+		}
+		scp.Db.SendMessages(ctx, subs)
+		for _, value := range subs {
+			value.Price = productPrice
+			scp.Db.UpdateSubscription(ctx, value)
+		}
+
+		// Saving the new price to the history of the ad
+		err = scp.Db.RecordPrice(ctx, pair.Url, productPrice)
+		if err != nil {
+			logger.Error("Couldn't record the price", "price", productPrice, "error", err)
+		}
+
+		scp.publishEvent(ctx, config.Event{
+			Kind:     config.EventPriceChange,
+			Url:      pair.Url,
+			OldPrice: pair.OldPrice,
+			NewPrice: productPrice,
+		}, subs)
+	}
+	return config.CheckResult{Status: config.CheckOk}
+}
+
+// Function that publishes the event if somebody listens to them.
+// If subscribers of the ad are not known yet, they are taken from the database
+func (scp *Scrapper) publishEvent(ctx context.Context, event config.Event, subs []config.Subscription) {
+	if !scp.Events.HasSubscribers() {
 		return
 	}
 
-	resp, err := scp.Client.Do(req)
+	if subs == nil {
+		var err error
+		subs, err = scp.Db.GetEmailsByUrl(ctx, event.Url)
+		if err != nil {
+			slog.Error("Couldn't get emails of subscribers", "url", event.Url, "error", err)
+		}
+	}
+	for _, value := range subs {
+		event.Emails = append(event.Emails, value.Email)
+	}
+	event.Time = time.Now()
+	scp.Events.Publish(event)
+	metrics.EventsPublished.WithLabelValues(event.Kind).Inc()
+}
+
+// Function that downloads the page of the url and brings the url to canonical form. If the ad id can not be
+// taken from the url (for example, it is a short link), the url after the redirects is used.
+// The page is downloaded once, its price is returned with the url
+func (scp *Scrapper) resolveListing(ctx context.Context, rawUrl string) (string, int64, config.GetPriceResponse) {
+	url, adId, err := utils.CanonicalUrl(rawUrl)
 	if err != nil {
-		response.Error = err
-		priceChan <- response
-		return
+		return "", 0, config.GetPriceResponse{Price: -1, Error: err}
 	}
 
-	if resp.StatusCode != 200 {
-		response.Error = errors.New("link is not available")
-		priceChan <- response
-		return
+	response, finalUrl := scp.getListing(withUserFetch(ctx), url)
+	if adId == 0 && finalUrl != "" {
+		resolved, resolvedId, err := utils.CanonicalUrl(finalUrl)
+		if err == nil {
+			url, adId = resolved, resolvedId
+		}
 	}
+	return url, adId, response
+}
+
+// Function that downloads the page once and takes the price of the ad or ads of the search from it,
+// without the queue. Used from the command line to check the parser. Returns the canonical url of the page
+func (scp *Scrapper) ScrapeOnce(ctx context.Context, rawUrl string) (string, config.GetPriceResponse, error) {
+	url, _, response := scp.resolveListing(ctx, rawUrl)
+	return url, response, response.Error
+}
+
+// Outcomes of the downloads counted in avito_scrape_attempts_total
+const (
+	outcomeOk          = "ok"
+	outcomeSearch      = "search"
+	outcomeNotModified = "not_modified"
+	outcomeRemoved     = "removed"
+	outcomeBlocked     = "blocked"
+	outcomeCooldown    = "cooldown"
+	outcomeParseError  = "parse_error"
+	outcomeError       = "error"
+)
+
+func (scp *Scrapper) getPrice(ctx context.Context, url string, priceChan chan config.GetPriceResponse) {
+	defer close(priceChan)
+	response, _ := scp.getListing(ctx, url)
+	priceChan <- response
+}
+
+// Function that downloads the page and takes the price from it.
+// Returns the url of the page after the redirects, it is empty if the page was not downloaded
+func (scp *Scrapper) getListing(ctx context.Context, url string) (config.GetPriceResponse, string) {
+	ctx, span := tracing.Start(ctx, "getPrice", attribute.String("url.full", url))
+	response, outcome, finalUrl := scp.scrape(ctx, url)
+	metrics.ScrapeAttempts.WithLabelValues(hostOf(url), outcome).Inc()
+	span.SetAttributes(attribute.String("scrape.outcome", outcome))
+	tracing.End(span, response.Error)
+	return response, finalUrl
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+// Function that downloads the page and takes the price of the ad or ads of the search from it
+func (scp *Scrapper) scrape(ctx context.Context, url string) (config.GetPriceResponse, string, string) {
+	response := config.GetPriceResponse{
+		Price: -1,
+		Error: nil,
+	}
+
+	page, err := scp.fetchPage(ctx, url)
 	if err != nil {
 		response.Error = err
-		priceChan <- response
-		return
+		switch err {
+		case errListingRemoved:
+			return response, outcomeRemoved, ""
+		case errBlocked:
+			return response, outcomeBlocked, ""
+		case errHostCooldown:
+			return response, outcomeCooldown, ""
+		}
+		return response, outcomeError, ""
+	}
+	if page.cached != nil {
+		return *page.cached, outcomeNotModified, page.url.String()
 	}
 
-	bodyString := string(body)
+	bodyString := page.body
 	priceStr := parsePrice(bodyString, `"dynx_price":`, ",")
 	price, err := strconv.Atoi(priceStr)
-	if err != nil {
-		response.Error = err
-		priceChan <- response
-		return
+	if priceStr == "" || err != nil {
+		// The page has no price of a single ad, it can be a search results page
+		if isSearchPage(bodyString) {
+			scp.canary.record(page.url.Host, true)
+			response.IsSearch = true
+			response.Listings = parseSearchResults(bodyString, page.url)
+			response.Price = 0
+			scp.cachePage(url, page, response)
+			return response, outcomeSearch, page.url.String()
+		}
+		// The page has neither the price nor ads, probably avito has changed its markup
+		scp.canary.record(page.url.Host, false)
+		response.Error = errPriceNotFound
+		return response, outcomeParseError, page.url.String()
 	}
 
+	scp.canary.record(page.url.Host, true)
 	response.Price = price
-	priceChan <- response
+	response.Info = parseListingInfo(bodyString)
+	scp.cachePage(url, page, response)
+	return response, outcomeOk, page.url.String()
+}
+
+func hostOf(rawUrl string) string {
+	parsed, err := neturl.Parse(rawUrl)
+	if err != nil || parsed.Host == "" {
+		return "unknown"
+	}
+	return parsed.Host
 }
 
 func parsePrice(target string, begin string, end string) string {
@@ -1,11 +1,13 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	fuzz "github.com/google/gofuzz"
@@ -27,11 +29,13 @@ func NewTestData() (Scrapper, *httptest.Server, sqlmock.Sqlmock) {
 	}))
 
 	scp := Scrapper{
-		Db:              &services.DB{DB: db},
-		Client:          testServer.Client(),
-		WorkerCount:     3,
-		scrapperTimeout: 0,
-		pairChannel:     make(chan config.CheckPriceRequest, 5),
+		Db:          &services.DB{DB: db},
+		Client:      testServer.Client(),
+		WorkerCount: 3,
+		options:     storeOptions(&scrapperOptions{}),
+		cooldowns:   newHostCooldowns(),
+		workers:     newWorkerPool(),
+		stop:        make(chan struct{}),
 	}
 	return scp, testServer, sqlMock
 }
@@ -39,12 +43,55 @@ func NewTestData() (Scrapper, *httptest.Server, sqlmock.Sqlmock) {
 func TestGetPrice(t *testing.T) {
 	scp, testServer, _ := NewTestData()
 	priceChan := make(chan config.GetPriceResponse, 1)
-	scp.getPrice(testServer.URL, priceChan)
+	scp.getPrice(context.Background(), testServer.URL, priceChan)
 	value := <-priceChan
 	assert.Equal(t, 8792009, value.Price)
 	assert.Nil(t, value.Error)
 }
 
+func TestResolveShortUrl(t *testing.T) {
+	requests := 0
+	testServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintln(w, avitoHTML)
+	}))
+	defer testServer.Close()
+	scp, _, _ := NewTestData()
+	redirectServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, testServer.URL+"/item/?utm_source=share#photo", http.StatusMovedPermanently)
+	}))
+	defer redirectServer.Close()
+	scp.Client = redirectServer.Client()
+
+	url, adId, response := scp.resolveListing(context.Background(), redirectServer.URL+"/short")
+	assert.Nil(t, response.Error)
+	assert.Equal(t, testServer.URL+"/item", url)
+	assert.Equal(t, int64(0), adId)
+	// The price is taken from the page at the end of the redirects, it is not downloaded again
+	assert.Equal(t, 8792009, response.Price)
+	assert.Equal(t, 1, requests)
+}
+
+func TestResolveAvitoUrl(t *testing.T) {
+	scp, testServer, _ := NewTestData()
+	// Avito is answered by the test server
+	var requested string
+	base := testServer.Client().Transport
+	scp.Client = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = req.URL.String()
+		req = req.Clone(req.Context())
+		req.URL.Host = testServer.Listener.Addr().String()
+		return base.RoundTrip(req)
+	})}
+
+	url, adId, response := scp.resolveListing(context.Background(), "http://m.avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290/?context=abc&utm_medium=x")
+	assert.Nil(t, response.Error)
+	assert.Equal(t, "https://www.avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290", url)
+	assert.Equal(t, url, requested)
+	assert.Equal(t, int64(1791027290), adId)
+	assert.Equal(t, 8792009, response.Price)
+}
+
 func TestWorkerStart(t *testing.T) {
 	scp, testServer, sqlMock := NewTestData()
 
@@ -66,19 +113,108 @@ func TestWorkerStart(t *testing.T) {
 		"SELECT acc_verified, email, price, url FROM subscription").
 		WithArgs(testServer.URL).WillReturnRows(rows)
 
-	for _, value := range pairs {
-		scp.pairChannel <- value
-	}
-	close(scp.pairChannel)
+	sqlMock.ExpectExec("UPDATE subscription").
+		WithArgs(true, "d_kokin@inbox.ru", 8792009, testServer.URL).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	sqlMock.ExpectExec("INSERT INTO price_history").
+		WithArgs(testServer.URL, 8792009, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	var wg sync.WaitGroup
-	for i := 0; i < scp.WorkerCount; i++ {
+	for _, value := range pairs {
 		wg.Add(1)
-		go scp.startWorker(&wg)
+		go func(pair config.CheckPriceRequest) {
+			defer wg.Done()
+			scp.checkPair(pair)
+		}(value)
 	}
 	wg.Wait()
 }
 
+func TestWorkerClaimsDueChecks(t *testing.T) {
+	scp, testServer, sqlMock := NewTestData()
+	queuePollInterval = time.Millisecond
+	defer func() { queuePollInterval = time.Second }()
+
+	scp.instanceId = "instance-1"
+
+	claimColumns := []string{"url", "is_search", "price", "subscribers", "active_days"}
+	sqlMock.ExpectQuery("UPDATE scrape_job SET leased_by").
+		WithArgs("instance-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(claimColumns).AddRow(testServer.URL, false, 8792009, 1, 0))
+	sqlMock.ExpectExec("UPDATE scrape_job SET next_check_at").
+		WithArgs(testServer.URL, "instance-1", float64(0), config.CheckOk, "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Nothing is due, the worker waits for the next poll
+	sqlMock.ExpectQuery("UPDATE scrape_job SET leased_by").
+		WithArgs("instance-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(claimColumns))
+
+	done := make(chan struct{})
+	go func() {
+		scp.startWorker(nil)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return sqlMock.ExpectationsWereMet() == nil
+	}, time.Second, time.Millisecond)
+	scp.Stop()
+	<-done
+}
+
+func TestWorkerPutsOffChecksOfBlockedHost(t *testing.T) {
+	scp, testServer, sqlMock := NewTestData()
+	queuePollInterval = time.Millisecond
+	defer func() { queuePollInterval = time.Second }()
+
+	scp.instanceId = "instance-1"
+	scp.cooldowns.block(hostOf(testServer.URL))
+
+	// The check is moved to the end of the pause without the download
+	claimColumns := []string{"url", "is_search", "price", "subscribers", "active_days"}
+	sqlMock.ExpectQuery("UPDATE scrape_job SET leased_by").
+		WithArgs("instance-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(claimColumns).AddRow(testServer.URL, false, 8792009, 1, 0))
+	sqlMock.ExpectExec("UPDATE scrape_job SET next_check_at").
+		WithArgs(testServer.URL, "instance-1", sqlmock.AnyArg(), config.CheckSkipped, errHostCooldown.Error()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Checks of other hosts are still taken
+	sqlMock.ExpectQuery("UPDATE scrape_job SET leased_by").
+		WithArgs("instance-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(claimColumns))
+
+	done := make(chan struct{})
+	go func() {
+		scp.startWorker(nil)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return sqlMock.ExpectationsWereMet() == nil
+	}, time.Second, time.Millisecond)
+	scp.Stop()
+	<-done
+}
+
+func TestRunningCheckRenewsLease(t *testing.T) {
+	scp, testServer, sqlMock := NewTestData()
+	leaseRenewInterval = time.Millisecond
+	defer func() { leaseRenewInterval = services.LeaseRenewInterval }()
+
+	scp.instanceId = "instance-1"
+	sqlMock.ExpectExec("UPDATE scrape_job SET lease_until").
+		WithArgs(testServer.URL, "instance-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	stop := scp.keepLease(testServer.URL)
+	assert.Eventually(t, func() bool {
+		return sqlMock.ExpectationsWereMet() == nil
+	}, time.Second, time.Millisecond)
+	stop()
+}
+
 func TestFuzzConstructor(t *testing.T) {
 	db, _, err := sqlmock.New()
 	if err != nil {
@@ -111,3 +247,13 @@ const avitoHTML = `window.dataLayer = [{"dynx_user":"a","dynx_region":"moskva","
  j.src = '//www.googletagmanager.com/gtm.js?id=' + i + dl;
  f.parentNode.insertBefore(j, f);
  })(window, document, 'script', 'dataLayer', 'GTM-KP9Q9H');`
+
+func TestScrapeOnce(t *testing.T) {
+	scp, testServer, _ := NewTestData()
+
+	url, response, err := scp.ScrapeOnce(context.Background(), testServer.URL+"/?utm_source=share")
+	assert.Nil(t, err)
+	assert.Equal(t, testServer.URL, url)
+	assert.Equal(t, 8792009, response.Price)
+	assert.False(t, response.IsSearch)
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"test_avito/config"
+)
+
+// Markers of the search results page of avito
+const (
+	searchPageMarker = `data-marker="catalog-serp"`
+	searchItemMarker = `data-marker="item"`
+)
+
+func isSearchPage(body string) bool {
+	return strings.Contains(body, searchPageMarker) || strings.Contains(body, searchItemMarker)
+}
+
+// Function that takes ads from the search results page. Relative links are resolved against the page url
+func parseSearchResults(body string, pageUrl *url.URL) []config.Listing {
+	listings := make([]config.Listing, 0, 50)
+
+	items := strings.Split(body, searchItemMarker)
+	for _, item := range items[1:] {
+		adId, err := strconv.ParseInt(parsePrice(item, `data-item-id="`, `"`), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		listing := config.Listing{AdId: adId}
+		listing.Price, _ = strconv.Atoi(parsePrice(item, `itemprop="price" content="`, `"`))
+
+		href := parsePrice(item, `href="`, `"`)
+		if link, err := pageUrl.Parse(href); err == nil && href != "" {
+			listing.Url = link.String()
+		}
+		listings = append(listings, listing)
+	}
+	return listings
+}
+
+// Function that notifies subscribers of the saved search about ads that were not seen before
+func (scp *Scrapper) checkNewListings(ctx context.Context, searchUrl string, listings []config.Listing) {
+	newListings, err := scp.Db.SaveSeenListings(ctx, searchUrl, listings)
+	if err != nil {
+		slog.Error("Couldn't save listings of the search", "url", searchUrl, "error", err)
+		return
+	}
+	if len(newListings) == 0 {
+		return
+	}
+
+	subs, err := scp.Db.GetEmailsByUrl(ctx, searchUrl)
+	if err != nil {
+		slog.Error("Couldn't get emails of subscribers", "url", searchUrl, "error", err)
+		return
+	}
+
+	scp.Db.SendNewListingsMessages(ctx, subs, newListings)
+	scp.publishEvent(ctx, config.Event{
+		Kind:     config.EventNewListings,
+		Url:      searchUrl,
+		Listings: newListings,
+	}, subs)
+}
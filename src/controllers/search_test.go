@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"test_avito/config"
+)
+
+const searchHTML = `<div data-marker="catalog-serp">
+<div data-marker="item" data-item-id="1791027290" class="iva-item">
+ <a href="/moskva/avtomobili/bmw_m5_2019_1791027290" itemprop="url">BMW M5</a>
+ <meta itemprop="price" content="8792009">
+</div>
+<div data-marker="item" data-item-id="1791027291" class="iva-item">
+ <a href="/moskva/avtomobili/audi_rs6_2020_1791027291" itemprop="url">Audi RS6</a>
+ <meta itemprop="price" content="9500000">
+</div>
+<div data-marker="item" class="iva-item-banner"></div>
+</div>`
+
+func TestParseSearchResults(t *testing.T) {
+	pageUrl, _ := url.Parse("https://www.avito.ru/moskva/avtomobili?q=bmw")
+
+	listings := parseSearchResults(searchHTML, pageUrl)
+	assert.Equal(t, []config.Listing{{
+		AdId:  1791027290,
+		Url:   "https://www.avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290",
+		Price: 8792009,
+	}, {
+		AdId:  1791027291,
+		Url:   "https://www.avito.ru/moskva/avtomobili/audi_rs6_2020_1791027291",
+		Price: 9500000,
+	}}, listings)
+}
+
+func TestGetPriceSearchPage(t *testing.T) {
+	scp, _, _ := NewTestData()
+	searchServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, searchHTML)
+	}))
+	defer searchServer.Close()
+	scp.Client = searchServer.Client()
+
+	priceChan := make(chan config.GetPriceResponse, 1)
+	scp.getPrice(context.Background(), searchServer.URL+"/moskva/avtomobili", priceChan)
+	value := <-priceChan
+	assert.Nil(t, value.Error)
+	assert.True(t, value.IsSearch)
+	assert.Len(t, value.Listings, 2)
+	assert.Equal(t, searchServer.URL+"/moskva/avtomobili/bmw_m5_2019_1791027290", value.Listings[0].Url)
+}
+
+func TestCheckNewListings(t *testing.T) {
+	scp, _, sqlMock := NewTestData()
+	scp.Events = NewBroker()
+	events := scp.Events.Subscribe(1)
+	defer scp.Events.Unsubscribe(events)
+
+	searchUrl := "https://www.avito.ru/moskva/avtomobili?q=bmw"
+	listings := []config.Listing{
+		{AdId: 1791027290, Url: "https://www.avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290", Price: 8792009},
+		{AdId: 1791027291, Url: "https://www.avito.ru/moskva/avtomobili/bmw_x5_2020_1791027291", Price: 9500000},
+	}
+
+	// The first ad was already seen, the second one is new
+	sqlMock.ExpectExec("INSERT INTO search_seen").
+		WithArgs(searchUrl, int64(1791027290), 8792009, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec("INSERT INTO search_seen").
+		WithArgs(searchUrl, int64(1791027291), 9500000, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	sqlMock.ExpectQuery("SELECT acc_verified, email, price, url FROM subscription").
+		WithArgs(searchUrl).
+		WillReturnRows(sqlmock.NewRows([]string{"acc_verified", "email", "price", "url"}).
+			AddRow(true, "d_kokin@inbox.ru", 0, searchUrl))
+
+	scp.checkNewListings(context.Background(), searchUrl, listings)
+	assert.Nil(t, sqlMock.ExpectationsWereMet())
+
+	event := <-events
+	assert.Equal(t, config.EventNewListings, event.Kind)
+	assert.Equal(t, searchUrl, event.Url)
+	assert.Equal(t, listings[1:], event.Listings)
+	assert.Equal(t, []string{"d_kokin@inbox.ru"}, event.Emails)
+}
+
+func TestCheckNewListingsNothingNew(t *testing.T) {
+	scp, _, sqlMock := NewTestData()
+
+	searchUrl := "https://www.avito.ru/moskva/avtomobili?q=bmw"
+	sqlMock.ExpectExec("INSERT INTO search_seen").
+		WithArgs(searchUrl, int64(1791027290), 8792009, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	scp.checkNewListings(context.Background(), searchUrl, []config.Listing{{AdId: 1791027290, Price: 8792009}})
+	assert.Nil(t, sqlMock.ExpectationsWereMet())
+}
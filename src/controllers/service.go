@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"test_avito/config"
+	"test_avito/src/logging"
+	"test_avito/src/tracing"
+	"test_avito/utils"
+)
+
+// Operations of the service shared by the HTTP and gRPC handlers.
+// Errors shown to the clients are returned as *ApiError
+
+// Subscribing the email to price changes of the ad or to new ads of the search results page
+func (env *EnvironmentNotification) Subscribe(ctx context.Context, req SubscriptionRequest) (config.Subscription, error) {
+	return env.subscribe(ctx, req, false)
+}
+
+// Subscribing the email without the confirmation letter, the email is considered confirmed.
+// Used by the operator from the command line
+func (env *EnvironmentNotification) SubscribeConfirmed(ctx context.Context, req SubscriptionRequest) (config.Subscription, error) {
+	return env.subscribe(ctx, req, true)
+}
+
+func (env *EnvironmentNotification) subscribe(ctx context.Context, req SubscriptionRequest, confirmed bool) (sub config.Subscription, err error) {
+	ctx, span := tracing.Start(ctx, "Subscribe", attribute.String("url.full", req.Url))
+	defer func() { tracing.End(span, err) }()
+
+	// Operator subscribes from the command line, its requests are not limited
+	if !confirmed {
+		err = env.Limits.allowIp(clientIp(ctx))
+		if err != nil {
+			return sub, err
+		}
+	}
+
+	// Validate the correctness of the url
+	url := req.Url
+	err = utils.CheckUrl(url)
+	if err != nil || url == "" {
+		return sub, newApiError(http.StatusBadRequest, ErrInvalidUrl, "url is not valid", "url")
+	}
+
+	// Validate the correctness of the email
+	email := req.Email
+	err = utils.CheckEmail(email)
+	if err != nil || email == "" {
+		return sub, newApiError(http.StatusBadRequest, ErrInvalidEmail, "email is not valid", "email")
+	}
+
+	if !confirmed {
+		err = env.Limits.allowEmail(email)
+		if err != nil {
+			return sub, err
+		}
+	}
+
+	// Only urls of the supported marketplaces are fetched
+	if !env.Limits.hostAllowed(urlHost(url)) {
+		return sub, rejectSubscription("host", newApiError(http.StatusBadRequest, ErrHostNotAllowed, "host of the url is not supported", "url"))
+	}
+
+	if !confirmed {
+		err = env.Limits.checkCaps(ctx, env, email)
+		if err != nil {
+			return sub, err
+		}
+	}
+
+	// Checking whether the user has confirmed the specified email
+	authChan := make(chan bool, 1)
+	go env.Db.IsAuthorized(ctx, email, authChan)
+
+	// Making a request to the avito website to get the price, 400th error in case of a nonexistent link.
+	// The url is brought to canonical form so that the same ad pasted in different ways is stored once,
+	// short links are resolved by the same request
+	url, adId, response := env.Scp.resolveListing(ctx, url)
+	if response.Error == errBlocked || response.Error == errHostCooldown {
+		return sub, newApiError(http.StatusServiceUnavailable, ErrTemporarilyUnavailable, "avito is not available now, try again later", "")
+	}
+	if errors.Is(response.Error, errForbiddenTarget) {
+		return sub, rejectSubscription("address", newApiError(http.StatusBadRequest, ErrHostNotAllowed, "address of the url is not allowed", "url"))
+	}
+	if response.Error != nil || response.Price == -1 {
+		return sub, newApiError(http.StatusBadRequest, ErrListingUnreachable, "price of the listing is not available", "url")
+	}
+	// Short links may lead to other hosts
+	if !env.Limits.hostAllowed(urlHost(url)) {
+		return sub, rejectSubscription("host", newApiError(http.StatusBadRequest, ErrHostNotAllowed, "host of the url is not supported", "url"))
+	}
+	url = env.storedUrl(ctx, url, adId)
+
+	// Checking the case when the same user sends a repeated url
+	dupChan := make(chan bool, 1)
+	go env.Db.IsDuplicate(ctx, email, url, dupChan)
+
+	isDuplicate := <-dupChan
+	isAuthorized := <-authChan || confirmed
+
+	// 400th error in case duplicate url
+	if isDuplicate {
+		return sub, newApiError(http.StatusBadRequest, ErrDuplicateSubscription, "email is already subscribed to this url", "url")
+	}
+
+	sub = config.Subscription{
+		AccVerified: isAuthorized,
+		Email:       email,
+		Url:         url,
+		Price:       response.Price,
+		AdId:        adId,
+		IsSearch:    response.IsSearch,
+	}
+
+	// Saving subscription info to database
+	// 500th error in case of internal database error
+	err = env.Db.SaveSubscription(ctx, sub)
+	if err != nil {
+		logging.FromContext(ctx).Error("Subscription was not saved", "url", url, "error", err)
+		return sub, newApiError(http.StatusInternalServerError, ErrInternal, "subscription was not saved", "")
+	}
+
+	// Url gets to the queue of the scrapper, it is checked only after the email is confirmed
+	err = env.Db.ScheduleCheck(ctx, url, sub.IsSearch)
+	if err != nil {
+		logging.FromContext(ctx).Error("Check of the url was not scheduled", "url", url, "error", err)
+	}
+
+	if sub.IsSearch {
+		// Ads which are already on the page are not new for the subscriber
+		_, err = env.Db.SaveSeenListings(ctx, url, response.Listings)
+		if err != nil {
+			logging.FromContext(ctx).Error("Listings of the search were not recorded", "url", url, "error", err)
+		}
+	} else {
+		// The first observed price starts the history of the ad
+		err = env.Db.RecordPrice(ctx, url, response.Price)
+		if err != nil {
+			logging.FromContext(ctx).Error("Price history was not recorded", "url", url, "error", err)
+		}
+
+		if !isEmptyInfo(response.Info) {
+			sub.Info = &response.Info
+			err = env.Db.SaveListingInfo(ctx, url, response.Info)
+			if err != nil {
+				logging.FromContext(ctx).Error("Description of the listing was not saved", "url", url, "error", err)
+			}
+		}
+	}
+
+	// Do not sending a confirmation email if the user has already confirmed it
+	if !isAuthorized {
+		err = env.Db.RecordMailConfirm(ctx, email)
+		if err != nil {
+			logging.FromContext(ctx).Error("Confirmation email was not sent", "error", err)
+			return sub, newApiError(http.StatusInternalServerError, ErrInternal, "confirmation email was not sent", "")
+		}
+	}
+	return sub, nil
+}
+
+// Function that returns the url under which the ad is stored. The same ad can be found by different urls,
+// the url of the first subscription to the ad id is used for all of them
+func (env *EnvironmentNotification) storedUrl(ctx context.Context, url string, adId int64) string {
+	if adId == 0 {
+		return url
+	}
+	storedUrl, err := env.Db.GetUrlByAdId(ctx, adId)
+	if err != nil {
+		return url
+	}
+	return storedUrl
+}
+
+// Function that finds the email by the token of the feed. The token is given only to the owner of
+// the confirmed email, so it proves that the subscriptions belong to the caller
+func (env *EnvironmentNotification) emailByToken(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", newApiError(http.StatusUnauthorized, ErrUnauthorized, "feed token is required", "token")
+	}
+	email, err := env.Db.GetEmailByFeedToken(ctx, token)
+	if err == sql.ErrNoRows {
+		return "", newApiError(http.StatusUnauthorized, ErrUnauthorized, "feed token is not valid", "token")
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("Owner of the feed token was not loaded", "error", err)
+		return "", newApiError(http.StatusInternalServerError, ErrInternal, "feed token was not checked", "")
+	}
+	return email, nil
+}
+
+// Removing the subscription to the ad. The subscriber is found by the token of the feed
+func (env *EnvironmentNotification) Unsubscribe(ctx context.Context, req SubscriptionRequest) error {
+	email, err := env.emailByToken(ctx, req.Token)
+	if err != nil {
+		return err
+	}
+	return env.unsubscribe(ctx, email, req.Url)
+}
+
+// Removing the subscription of the email to the ad without the token. Used by the operator from the command line
+func (env *EnvironmentNotification) UnsubscribeEmail(ctx context.Context, req SubscriptionRequest) error {
+	err := utils.CheckEmail(req.Email)
+	if err != nil || req.Email == "" {
+		return newApiError(http.StatusBadRequest, ErrInvalidEmail, "email is not valid", "email")
+	}
+	return env.unsubscribe(ctx, req.Email, req.Url)
+}
+
+// The url is resolved like in the subscription: the ad is looked up by its id, and short links are
+// followed to the ad only if the url itself is not found, so the page is not downloaded without need
+func (env *EnvironmentNotification) unsubscribe(ctx context.Context, email string, rawUrl string) error {
+	url, adId, err := utils.CanonicalUrl(rawUrl)
+	if err != nil {
+		return newApiError(http.StatusBadRequest, ErrInvalidUrl, "url is not valid", "url")
+	}
+	url = env.storedUrl(ctx, url, adId)
+
+	deleted, err := env.Db.DeleteSubscription(ctx, email, url)
+	if err == nil && !deleted && adId == 0 {
+		resolved, resolvedId, _ := env.Scp.resolveListing(ctx, url)
+		if resolved != "" && resolved != url {
+			url = env.storedUrl(ctx, resolved, resolvedId)
+			deleted, err = env.Db.DeleteSubscription(ctx, email, url)
+		}
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("Subscription was not removed", "url", url, "error", err)
+		return newApiError(http.StatusInternalServerError, ErrInternal, "subscription was not removed", "")
+	}
+	if !deleted {
+		return newApiError(http.StatusNotFound, ErrSubscriptionNotFound, "subscription is not found", "url")
+	}
+
+	err = env.Db.RemoveCheck(ctx, url)
+	if err != nil {
+		logging.FromContext(ctx).Error("Check of the url was not removed", "url", url, "error", err)
+	}
+	return nil
+}
+
+// All subscriptions of the owner of the feed token
+func (env *EnvironmentNotification) ListSubscriptions(ctx context.Context, token string) ([]config.Subscription, error) {
+	email, err := env.emailByToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return env.listSubscriptions(ctx, email)
+}
+
+// All subscriptions of the email without the token. Used by the operator from the command line
+func (env *EnvironmentNotification) ListSubscriptionsOfEmail(ctx context.Context, email string) ([]config.Subscription, error) {
+	err := utils.CheckEmail(email)
+	if err != nil || email == "" {
+		return nil, newApiError(http.StatusBadRequest, ErrInvalidEmail, "email is not valid", "email")
+	}
+	return env.listSubscriptions(ctx, email)
+}
+
+func (env *EnvironmentNotification) listSubscriptions(ctx context.Context, email string) ([]config.Subscription, error) {
+	subs, err := env.Db.GetSubscriptionsByEmail(ctx, email)
+	if err != nil {
+		return nil, newApiError(http.StatusInternalServerError, ErrInternal, "subscriptions were not loaded", "")
+	}
+	return subs, nil
+}
+
+// Recorded prices of the ad, the oldest first
+func (env *EnvironmentNotification) PriceHistory(ctx context.Context, rawUrl string) ([]config.PricePoint, error) {
+	url, _, err := utils.CanonicalUrl(rawUrl)
+	if err != nil {
+		return nil, newApiError(http.StatusBadRequest, ErrInvalidUrl, "url is not valid", "url")
+	}
+
+	points, err := env.Db.GetPriceHistory(ctx, url)
+	if err != nil {
+		return nil, newApiError(http.StatusInternalServerError, ErrInternal, "price history was not loaded", "")
+	}
+	return points, nil
+}
+
+// Confirming the email or sending a new letter if the confirmation time has expired.
+// Returns the token of the user's feed, it is empty if the email is not confirmed yet
+func (env *EnvironmentNotification) ConfirmEmail(ctx context.Context, hash string) (string, error) {
+	email, err := env.Db.Confirm(ctx, hash)
+	if err == sql.ErrNoRows {
+		return "", newApiError(http.StatusNotFound, ErrConfirmationNotFound, "confirmation hash is unknown", "hash")
+	}
+	if err != nil {
+		return "", newApiError(http.StatusInternalServerError, ErrInternal, "email was not confirmed", "")
+	}
+	if email == "" {
+		return "", nil
+	}
+
+	token, err := env.Db.GetFeedToken(ctx, email)
+	if err != nil {
+		logging.FromContext(ctx).Error("Feed token was not created", "error", err)
+		return "", nil
+	}
+	return token, nil
+}
+
+// Latest price changes of all ads of the feed's owner
+func (env *EnvironmentNotification) Feed(ctx context.Context, token string) ([]config.PriceChangeRecord, error) {
+	email, err := env.Db.GetEmailByFeedToken(ctx, token)
+	if err == sql.ErrNoRows {
+		return nil, newApiError(http.StatusNotFound, ErrFeedNotFound, "feed is not found", "token")
+	}
+	if err != nil {
+		return nil, newApiError(http.StatusInternalServerError, ErrInternal, "feed was not loaded", "")
+	}
+
+	changes, err := env.Db.GetRecentPriceChanges(ctx, email, feedSize)
+	if err != nil {
+		return nil, newApiError(http.StatusInternalServerError, ErrInternal, "feed was not loaded", "")
+	}
+	return changes, nil
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	oldProvider, oldPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(oldProvider)
+		otel.SetTextMapPropagator(oldPropagator)
+	})
+	return recorder
+}
+
+func spansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+func TestSubscribeIsTraced(t *testing.T) {
+	recorder := recordSpans(t)
+	scp, testServer, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	mock.MatchExpectationsInOrder(false)
+
+	mock.ExpectQuery("SELECT COALESCE\\(bool_or\\(acc_verified\\), false\\)").
+		WithArgs("d_kokin@inbox.ru").
+		WillReturnRows(mock.NewRows([]string{"acc_verified"}).AddRow(true))
+	mock.ExpectQuery("SELECT DISTINCT url FROM subscription").
+		WithArgs("d_kokin@inbox.ru", testServer.URL).
+		WillReturnError(errors.New("no rows"))
+	mock.ExpectExec("INSERT INTO subscription").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO scrape_job").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// The trace of the client is continued
+	parent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	req := httptest.NewRequest("POST", "/api/v1/subscribe?url="+testServer.URL+"&email=d_kokin@inbox.ru", nil)
+	req.Header.Set("traceparent", parent)
+	w := httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	spans := spansByName(recorder)
+	server, ok := spans["POST /api/v1/subscribe"]
+	assert.True(t, ok)
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", server.SpanContext().TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", server.Parent().SpanID().String())
+
+	for _, name := range []string{"Subscribe", "getPrice", "fetchPage",
+		"db.IsAuthorized", "db.IsDuplicate", "db.SaveSubscription", "db.ScheduleCheck"} {
+		span, ok := spans[name]
+		if assert.True(t, ok, name) {
+			assert.Equal(t, server.SpanContext().TraceID(), span.SpanContext().TraceID(), name)
+		}
+	}
+	assert.Equal(t, spans["Subscribe"].SpanContext().SpanID(), spans["getPrice"].Parent().SpanID())
+	assert.Equal(t, spans["Subscribe"].SpanContext().SpanID(), spans["db.IsDuplicate"].Parent().SpanID())
+}
+
+func TestServerErrorMarksSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	scp, _, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	mock.ExpectQuery("SELECT email FROM feed_token").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("d_kokin@inbox.ru"))
+	mock.ExpectQuery("SELECT s.acc_verified").WillReturnError(errors.New("internal error"))
+
+	w := httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/subscriptions?token=feed-token", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	span := spansByName(recorder)["GET /api/v1/subscriptions"]
+	assert.NotNil(t, span)
+	assert.Equal(t, codes.Error, span.Status().Code)
+}
+
+func TestFeedQueriesAreTraced(t *testing.T) {
+	recorder := recordSpans(t)
+	scp, _, mock := NewTestData()
+	env := EnvironmentNotification{Db: scp.Db, Scp: scp}
+	expectFeed(mock, time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC))
+
+	w := httptest.NewRecorder()
+	NewRouter(&env).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/feed/"+feedToken+".atom", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	spans := spansByName(recorder)
+	server := spans["GET /api/v1/feed/{token}.atom"]
+	if assert.NotNil(t, server) {
+		span, ok := spans["db.GetEmailByFeedToken"]
+		if assert.True(t, ok) {
+			assert.Equal(t, server.SpanContext().SpanID(), span.Parent().SpanID())
+		}
+	}
+}
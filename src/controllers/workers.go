@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"sync"
+)
+
+// Workers of the scrapper. They can be paused, added or removed while the scrapper works.
+// Shared by all copies of the Scrapper
+type workerPool struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	run     func(quit <-chan struct{})
+	stop    <-chan struct{}
+	quits   []chan struct{}
+	paused  bool
+	running bool
+	// Number of workers set before the start, it replaces the number from the config
+	initial int
+}
+
+func newWorkerPool() *workerPool {
+	return &workerPool{}
+}
+
+// Function that starts count workers and waits until all of them are stopped by the stop channel
+func (p *workerPool) start(count int, stop <-chan struct{}, run func(quit <-chan struct{})) {
+	p.mu.Lock()
+	if p.initial > 0 {
+		count = p.initial
+	}
+	if count <= 0 {
+		p.mu.Unlock()
+		return
+	}
+	p.run = run
+	p.stop = stop
+	p.running = true
+	p.resize(count)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+
+	p.mu.Lock()
+	p.running = false
+	p.quits = nil
+	p.mu.Unlock()
+}
+
+// Function that changes the number of workers. Removed workers finish their current checks.
+// False if the pool is not started or is stopping
+func (p *workerPool) setCount(count int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running {
+		return false
+	}
+	select {
+	case <-p.stop:
+		return false
+	default:
+	}
+	p.resize(count)
+	return true
+}
+
+// Function that changes the number of workers of the running pool,
+// otherwise the number is used when the pool is started
+func (p *workerPool) setCountOrInitial(count int) {
+	if p.setCount(count) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.initial = count
+}
+
+// Must be called with the lock
+func (p *workerPool) resize(count int) {
+	for len(p.quits) < count {
+		quit := make(chan struct{})
+		p.quits = append(p.quits, quit)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.run(quit)
+		}()
+	}
+	for len(p.quits) > count {
+		last := len(p.quits) - 1
+		close(p.quits[last])
+		p.quits = p.quits[:last]
+	}
+}
+
+// Number of workers, it is 0 if the scrapper is not started
+func (p *workerPool) count() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.quits)
+}
+
+func (p *workerPool) setPaused(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = paused
+}
+
+func (p *workerPool) isPaused() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
@@ -8,5 +8,88 @@ CREATE TABLE if not exists subscription (
     acc_verified bool,
     email varchar(32),
     price int,
-    url varchar(128)
-)
+    url varchar(512),
+    ad_id bigint,
+    is_search bool DEFAULT false
+);
+
+ALTER TABLE subscription ADD COLUMN if not exists ad_id bigint;
+ALTER TABLE subscription ADD COLUMN if not exists is_search bool DEFAULT false;
+ALTER TABLE subscription ALTER COLUMN url TYPE varchar(512);
+
+CREATE TABLE if not exists search_seen (
+    url varchar(512),
+    ad_id bigint,
+    price int,
+    seen_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (url, ad_id)
+);
+
+CREATE TABLE if not exists listing (
+    url varchar(512) PRIMARY KEY,
+    title varchar(256),
+    image_url varchar(512),
+    location varchar(256),
+    seller varchar(256),
+    published_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE if not exists scrape_job (
+    url varchar(512) PRIMARY KEY,
+    is_search bool DEFAULT false,
+    next_check_at TIMESTAMP WITH TIME ZONE,
+    leased_by varchar(128),
+    lease_until TIMESTAMP WITH TIME ZONE,
+    last_check_at TIMESTAMP WITH TIME ZONE,
+    last_status varchar(16),
+    last_error varchar(512),
+    failures int DEFAULT 0
+);
+
+ALTER TABLE scrape_job ADD COLUMN if not exists leased_by varchar(128);
+ALTER TABLE scrape_job ADD COLUMN if not exists lease_until TIMESTAMP WITH TIME ZONE;
+ALTER TABLE scrape_job ADD COLUMN if not exists last_check_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE scrape_job ADD COLUMN if not exists last_status varchar(16);
+ALTER TABLE scrape_job ADD COLUMN if not exists last_error varchar(512);
+ALTER TABLE scrape_job ADD COLUMN if not exists failures int DEFAULT 0;
+
+CREATE INDEX if not exists scrape_job_next_check_idx ON scrape_job (next_check_at);
+
+-- Checks of the subscriptions created before the queue are spread over the first minute
+INSERT INTO scrape_job (url, is_search, next_check_at)
+SELECT DISTINCT ON (url) url, COALESCE(is_search, false), now() + random() * interval '1 minute' FROM subscription
+ON CONFLICT (url) DO NOTHING;
+
+CREATE TABLE if not exists scrapper_instance (
+    id varchar(128) PRIMARY KEY,
+    started_at TIMESTAMP WITH TIME ZONE,
+    heartbeat_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE if not exists price_history (
+    url varchar(512),
+    price int,
+    checked_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE price_history ALTER COLUMN url TYPE varchar(512);
+
+CREATE INDEX if not exists price_history_url_idx ON price_history (url, checked_at);
+
+CREATE TABLE if not exists feed_token (
+    email varchar(32) UNIQUE,
+    token varchar(64) UNIQUE
+);
+
+-- Notifications which were not delivered, they are kept until the operator sends them again
+CREATE TABLE if not exists failed_notification (
+    id serial PRIMARY KEY,
+    channel varchar(16),
+    email varchar(32),
+    url varchar(512),
+    message text,
+    error varchar(512),
+    attempts int DEFAULT 1,
+    failed_at TIMESTAMP WITH TIME ZONE
+);
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	HttpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "avito_http_requests_total",
+		Help: "Requests to the HTTP API by route, method and status",
+	}, []string{"route", "method", "status"})
+
+	HttpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "avito_http_request_duration_seconds",
+		Help:    "Duration of requests to the HTTP API by route",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	ScrapeAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "avito_scrape_attempts_total",
+		Help: "Downloads of ads and searches by host and outcome",
+	}, []string{"host", "outcome"})
+
+	FetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "avito_fetch_duration_seconds",
+		Help:    "Duration of downloading one page by host",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 3, 5, 10},
+	}, []string{"host"})
+
+	PriceChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "avito_price_changes_total",
+		Help: "Changes of ad prices found by the scrapper",
+	})
+
+	Notifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "avito_notifications_total",
+		Help: "Sent notifications by channel and result",
+	}, []string{"channel", "result"})
+
+	EventsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "avito_events_published_total",
+		Help: "Events of the scrapper published to the streams of this instance by kind",
+	}, []string{"kind"})
+
+	QueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "avito_queue_due_checks",
+		Help: "Checks in the queue whose time has come, it grows if the scrapper does not keep up",
+	})
+
+	CheckDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "avito_check_duration_seconds",
+		Help:    "Duration of one check of an ad or search taken from the queue, including notifications",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 30},
+	})
+
+	SubscribeRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "avito_subscribe_rejected_total",
+		Help: "Subscription requests rejected by the limits by reason",
+	}, []string{"reason"})
+
+	FetchBytesRead = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "avito_fetch_bytes_read_total",
+		Help: "Bytes of the pages received from the network by the scrapper",
+	})
+
+	FetchBytesSaved = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "avito_fetch_bytes_saved_total",
+		Help: "Bytes which were not downloaded thanks to 304 answers, compression and pages not read to the end",
+	})
+
+	FetchNotModified = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "avito_fetch_not_modified_total",
+		Help: "Pages which were answered with 304 Not Modified",
+	})
+
+	FetchBlocked = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "avito_fetch_blocked_total",
+		Help: "Block and captcha pages served to the scrapper",
+	})
+
+	DbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "avito_db_query_duration_seconds",
+		Help:    "Duration of database queries by the operation of the datastore",
+		Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	}, []string{"query"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		HttpRequests,
+		HttpRequestDuration,
+		ScrapeAttempts,
+		FetchDuration,
+		PriceChanges,
+		Notifications,
+		EventsPublished,
+		QueueDepth,
+		CheckDuration,
+		SubscribeRejected,
+		FetchBytesRead,
+		FetchBytesSaved,
+		FetchNotModified,
+		FetchBlocked,
+		DbQueryDuration,
+	)
+}
+
+// Results of the notifications
+const (
+	NotificationSent   = "sent"
+	NotificationFailed = "failed"
+)
+
+// Function that counts the sent notification. Channel is "email", "confirmation", "alert" or "test"
+func CountNotification(channel string, err error) {
+	result := NotificationSent
+	if err != nil {
+		result = NotificationFailed
+	}
+	Notifications.WithLabelValues(channel, result).Inc()
+}
@@ -0,0 +1,13 @@
+package rpc
+
+import (
+	"google.golang.org/grpc"
+)
+
+// Creating a gRPC server with registered notification service.
+// Messages and the service are generated from api/notification.proto by scripts/protoc.sh
+func NewServer(srv NotificationServer, opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(opts...)
+	RegisterNotificationServer(server, srv)
+	return server
+}
@@ -1,10 +1,9 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"golang.org/x/crypto/bcrypt"
-	"net/smtp"
-	"os"
 	"time"
 
 	"test_avito/config"
@@ -21,9 +20,9 @@ func addressGenerator(email string) (str string) {
 }
 
 // True if email subscribed on this url
-func (db *DB) IsDuplicate(email string, url string, dupChan chan bool) {
+func (db *DB) IsDuplicate(ctx context.Context, email string, url string, dupChan chan bool) {
 	defer close(dupChan)
-	row := db.QueryRow("SELECT DISTINCT url FROM subscription where email = $1 AND url = $2", email, url)
+	row := db.queryRow(ctx, "IsDuplicate", "SELECT DISTINCT url FROM subscription where email = $1 AND url = $2", email, url)
 
 	var duplicate string
 	err := row.Scan(&duplicate)
@@ -34,10 +33,11 @@ func (db *DB) IsDuplicate(email string, url string, dupChan chan bool) {
 	dupChan <- true
 }
 
-// True if email is verified (acc_verified == true)
-func (db *DB) IsAuthorized(email string, authChan chan bool) {
+// True if email is verified (acc_verified == true). Subscriptions of the email waiting for the
+// confirmation do not make it verified
+func (db *DB) IsAuthorized(ctx context.Context, email string, authChan chan bool) {
 	defer close(authChan)
-	row := db.QueryRow("SELECT DISTINCT acc_verified FROM subscription where email = $1", email)
+	row := db.queryRow(ctx, "IsAuthorized", "SELECT COALESCE(bool_or(acc_verified), false) FROM subscription where email = $1", email)
 
 	var isAuthorized bool
 	err := row.Scan(&isAuthorized)
@@ -45,21 +45,23 @@ func (db *DB) IsAuthorized(email string, authChan chan bool) {
 		authChan <- false
 		return
 	}
-	authChan <- true
+	authChan <- isAuthorized
 }
 
 // Creating a new email waiting for confirmation
-func (db *DB) RecordMailConfirm(email string) error {
+func (db *DB) RecordMailConfirm(ctx context.Context, email string) error {
 	secret := addressGenerator(email)
 	deadlineTime := time.Now().Add(24 * time.Hour)
-	_, err := db.Exec("INSERT INTO auth_confirmation (email, hash, deadline) values ($1, $2, $3)",
+	// The email which is already waiting for the confirmation keeps its link, the letter is sent again
+	_, err := db.exec(ctx, "RecordMailConfirm", "INSERT INTO auth_confirmation (email, hash, deadline) values ($1, $2, $3) "+
+		"ON CONFLICT (email) DO NOTHING",
 		email, secret, deadlineTime)
 	if err != nil {
 		return err
 	}
 
 	// Sending to user message with confirmation link
-	err = db.sendMail(email)
+	err = db.sendMail(ctx, email)
 	if err != nil {
 		return err
 	}
@@ -67,15 +69,15 @@ func (db *DB) RecordMailConfirm(email string) error {
 }
 
 // Function which update auth_confirmation if the confirmation time has expired
-func (db *DB) confirmFieldUpdate(email string, hash string) (err error) {
-	_, err = db.Exec("UPDATE auth_confirmation SET hash = $1, deadline = $2 where email = $3",
+func (db *DB) confirmFieldUpdate(ctx context.Context, email string, hash string) (err error) {
+	_, err = db.exec(ctx, "UpdateMailConfirm", "UPDATE auth_confirmation SET hash = $1, deadline = $2 where email = $3",
 		hash, time.Now().Add(24*time.Hour), email)
 	return err
 }
 
 // Function that sends a message to the user at the specified email address
-func (db *DB) sendMail(email string) error {
-	row := db.QueryRow("SELECT * FROM auth_confirmation WHERE email = $1", email)
+func (db *DB) sendMail(ctx context.Context, email string) error {
+	row := db.queryRow(ctx, "LoadMailConfirm", "SELECT * FROM auth_confirmation WHERE email = $1", email)
 
 	var obj config.AuthConfirmation
 	err := row.Scan(&obj.Email, &obj.Hash, &obj.Deadline)
@@ -83,48 +85,43 @@ func (db *DB) sendMail(email string) error {
 		return err
 	}
 
-	serviceMail, _ := os.LookupEnv("service_mail")
-	password, _ := os.LookupEnv("password")
-
-	msg := fmt.Sprintf(msgConst, serviceMail, email, url+obj.Hash)
-
-	err = smtp.SendMail("smtp.gmail.com:587",
-		smtp.PlainAuth(
-			"",
-			serviceMail,
-			password,
-			"smtp.gmail.com"),
-		serviceMail, []string{email}, []byte(msg))
+	// Only the owner of the mailbox gets the letter, so the token of the feed is sent with the link
+	msg := fmt.Sprintf(msgConst, mailAccount().Address, email, url+obj.Hash) + db.feedFooter(ctx, email)
 
+	err = deliverMail(ctx, "confirmation", email, msg)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// Function which confirm email or send a new email if the confirmation time has expired
-func (db *DB) Confirm(hash string) error {
+// Function which confirm email or send a new email if the confirmation time has expired.
+// Returns the confirmed email, it is empty if a new email was sent
+func (db *DB) Confirm(ctx context.Context, hash string) (string, error) {
 	var authInfo config.AuthConfirmation
-	row := db.QueryRow("SELECT * FROM auth_confirmation WHERE hash = $1", hash)
+	row := db.queryRow(ctx, "Confirm", "SELECT * FROM auth_confirmation WHERE hash = $1", hash)
 	err := row.Scan(&authInfo.Email, &authInfo.Hash, &authInfo.Deadline)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if authInfo.Deadline.Before(time.Now()) {
 		newHash := addressGenerator(authInfo.Email)
-		err = db.confirmFieldUpdate(authInfo.Email, newHash)
+		err = db.confirmFieldUpdate(ctx, authInfo.Email, newHash)
 		if err != nil {
-			return err
+			return "", err
 		}
-		err = db.sendMail(authInfo.Email)
-		return err
+		err = db.sendMail(ctx, authInfo.Email)
+		return "", err
 	} else {
-		_, err = db.Exec("UPDATE subscription SET acc_verified = true where email = $1", authInfo.Email)
+		_, err = db.exec(ctx, "Confirm", "UPDATE subscription SET acc_verified = true where email = $1", authInfo.Email)
 		if err != nil {
-			return err
+			return "", err
 		}
-		_, err = db.Exec("DELETE FROM auth_confirmation WHERE hash = $1", hash)
-		return err
+		_, err = db.exec(ctx, "Confirm", "DELETE FROM auth_confirmation WHERE hash = $1", hash)
+		if err != nil {
+			return "", err
+		}
+		return authInfo.Email, nil
 	}
 }
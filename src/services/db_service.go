@@ -4,13 +4,20 @@ import (
 	"database/sql"
 	"fmt"
 	_ "github.com/lib/pq"
-	"log"
+	"log/slog"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"test_avito/config"
 )
 
+// Key of the advisory lock taken while the scheme of the database is created
+const setupLockKey = 7461766974
+
+// Set when Setup has applied the scheme, readiness of the service depends on it
+var schemaReady int32
+
 // structure for functions that access the database
 type DB struct {
 	*sql.DB
@@ -40,66 +47,107 @@ func NewDB(conf config.Config) (*DB, error) {
 	if err = db.Ping(); err != nil {
 		return nil, err
 	}
-	log.Println("Successfully connected!")
+	slog.Info("Successfully connected!")
 	return &DB{db}, nil
 }
 
-func Setup(filename string, db *DB) {
+func Setup(filename string, db *DB) error {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Println("Setupfile opening error: ", err)
-		return
+		slog.Error("Setupfile opening error", "file", filename, "error", err)
+		return err
 	}
 	defer file.Close()
 
 	stat, err := file.Stat()
 	if err != nil {
-		log.Println("Error after opening setupfile: ", err)
-		return
+		slog.Error("Error after opening setupfile", "file", filename, "error", err)
+		return err
 	}
 
 	bs := make([]byte, stat.Size())
 	_, err = file.Read(bs)
 	if err != nil {
-		log.Println("Error after opening setupfile: ", err)
-		panic(err)
+		slog.Error("Error after opening setupfile", "file", filename, "error", err)
+		return err
+	}
+
+	// Several instances of the service can start at the same time,
+	// the lock lets only one of them change the scheme at a moment
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Command error", "error", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", setupLockKey)
+	if err != nil {
+		slog.Error("Setup lock error", "error", err)
+		return err
 	}
 
 	command := string(bs)
-	_, err = db.Exec(command)
+	_, err = tx.Exec(command)
 	if err != nil {
-		log.Println("Command error")
+		slog.Error("Command error", "error", err)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		slog.Error("Command error", "error", err)
+		return err
 	}
+	atomic.StoreInt32(&schemaReady, 1)
+	return nil
 }
 
-func ConnectToDB(conf config.Config) *DB {
-	var db *DB
-	chanDB := make(chan *DB, 1)
-	timeout := time.After(time.Second * 15)
-	go func() {
-		for {
-			db, err := NewDB(conf)
-			if err != nil {
-				log.Println(err)
-				time.Sleep(time.Millisecond * 1500)
-			} else {
-				chanDB <- db
-				return
-			}
-		}
-	}()
+// True if the scheme of the database is created by this instance
+func SchemaReady() bool {
+	return atomic.LoadInt32(&schemaReady) == 1
+}
+
+// Opening the database without connecting to it, the connection is made by the first query
+func OpenDB(conf config.Config) (*DB, error) {
+	db, err := sql.Open("postgres", ReadDatabaseSettings(conf))
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db}, nil
+}
+
+// Pauses between attempts to connect to the database, postgres can start longer than the service
+const (
+	connectRetryMin = 1500 * time.Millisecond
+	connectRetryMax = 30 * time.Second
+)
 
-MAIN:
+// Function that repeats the attempt with growing pauses until it succeeds
+func retryWithBackoff(what string, attempt func() error) {
+	pause := connectRetryMin
 	for {
-		select {
-		case database := <-chanDB:
-			db = database
-			log.Println("Connected to database")
-			break MAIN
-		case <-timeout:
-			log.Println("Timout: connection was not established")
-			panic("timeout")
+		err := attempt()
+		if err == nil {
+			return
+		}
+		slog.Warn(what, "next_attempt_in", pause.String(), "error", err)
+		time.Sleep(pause)
+		pause *= 2
+		if pause > connectRetryMax {
+			pause = connectRetryMax
 		}
 	}
-	return db
+}
+
+// Function that waits until the database accepts connections instead of giving up,
+// so the service can be started before the database
+func WaitForDB(db *DB) {
+	retryWithBackoff("Database is not available", db.Ping)
+	slog.Info("Connected to database")
+}
+
+// Function that creates the scheme of the database, retrying while the database is not available
+func SetupWithRetry(filename string, db *DB) {
+	retryWithBackoff("Scheme of the database is not created", func() error {
+		return Setup(filename, db)
+	})
 }
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"unicode/utf8"
+
+	"test_avito/config"
+)
+
+// Function that sends the notification and keeps it in failed_notification if it was not delivered,
+// so the operator can send it again later. Nothing is sent or kept without the mail account
+func (db *DB) deliverOrKeep(ctx context.Context, channel string, to string, url string, msg string) {
+	err := deliverMail(ctx, channel, to, msg)
+	if err == nil {
+		return
+	}
+	// Without the mail account nothing can be delivered later, so the notification is not kept
+	if err == ErrMailDisabled {
+		slog.Debug("Notification is not sent, the mail account is not set", "channel", channel, "email", to, "url", url)
+		return
+	}
+	slog.Error("Notification was not sent", "channel", channel, "email", to, "url", url, "error", err)
+
+	_, err = db.exec(ctx, "KeepFailedNotification", "INSERT INTO failed_notification (channel, email, url, message, error, attempts, failed_at) "+
+		"values ($1, $2, $3, $4, $5, 1, now())",
+		channel, to, url, msg, truncate(err.Error(), 512))
+	if err != nil {
+		slog.Error("Failed notification was not kept", "channel", channel, "email", to, "url", url, "error", err)
+	}
+}
+
+// Notifications which were not delivered, the oldest first
+func (db *DB) GetFailedNotifications(ctx context.Context) ([]config.FailedNotification, error) {
+	notifications := make([]config.FailedNotification, 0, 8)
+
+	rows, err := db.query(ctx, "GetFailedNotifications", "SELECT id, channel, email, COALESCE(url, ''), error, attempts, failed_at "+
+		"FROM failed_notification ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n config.FailedNotification
+		err = rows.Scan(&n.Id, &n.Channel, &n.Email, &n.Url, &n.Error, &n.Attempts, &n.FailedAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// Function that sends the failed notification again. It is removed if it is delivered, otherwise
+// the error and the number of attempts are updated. sql.ErrNoRows if there is no such notification
+func (db *DB) ResendFailedNotification(ctx context.Context, id int64) error {
+	var channel, email, msg string
+	row := db.queryRow(ctx, "ResendFailedNotification", "SELECT channel, email, message FROM failed_notification WHERE id = $1", id)
+	err := row.Scan(&channel, &email, &msg)
+	if err != nil {
+		return err
+	}
+
+	sendErr := deliverMail(ctx, channel, email, msg)
+	if sendErr == ErrMailDisabled {
+		return sendErr
+	}
+	if sendErr == nil {
+		_, err = db.exec(ctx, "ResendFailedNotification", "DELETE FROM failed_notification WHERE id = $1", id)
+		return err
+	}
+
+	_, err = db.exec(ctx, "ResendFailedNotification", "UPDATE failed_notification SET error = $2, attempts = attempts + 1, failed_at = now() WHERE id = $1",
+		id, truncate(sendErr.Error(), 512))
+	if err != nil {
+		slog.Error("Failed notification was not updated", "id", id, "error", err)
+	}
+	return sendErr
+}
+
+// Function that cuts the string to the size in characters, varchar columns of the database count them so
+func truncate(s string, size int) string {
+	if utf8.RuneCountInString(s) <= size {
+		return s
+	}
+	return string([]rune(s)[:size])
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// Link to the feed in the letters, the host is the same as in the confirmation link
+const (
+	feedUrl     = "127.0.0.1:8080/api/v1/feed/%s.atom"
+	feedMessage = "\n\nAll price changes of your ads: %s\nKeep this token to list and remove your subscriptions: %s"
+)
+
+func feedTokenGenerator() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Returns the secret token of the email's feed, creating it on the first call
+func (db *DB) GetFeedToken(ctx context.Context, email string) (string, error) {
+	token, err := feedTokenGenerator()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.exec(ctx, "GetFeedToken", "INSERT INTO feed_token (email, token) values ($1, $2) ON CONFLICT (email) DO NOTHING",
+		email, token)
+	if err != nil {
+		return "", err
+	}
+
+	row := db.queryRow(ctx, "GetFeedToken", "SELECT token FROM feed_token WHERE email = $1", email)
+	err = row.Scan(&token)
+	return token, err
+}
+
+func (db *DB) GetEmailByFeedToken(ctx context.Context, token string) (string, error) {
+	row := db.queryRow(ctx, "GetEmailByFeedToken", "SELECT email FROM feed_token WHERE token = $1", token)
+
+	var email string
+	err := row.Scan(&email)
+	return email, err
+}
+
+// Function that returns the end of the letter with the feed of the email and its token, so the user
+// can find the token again. The letter is sent without it if the token is not loaded
+func (db *DB) feedFooter(ctx context.Context, email string) string {
+	token, err := db.GetFeedToken(ctx, email)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf(feedMessage, fmt.Sprintf(feedUrl, token), token)
+}
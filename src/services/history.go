@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"test_avito/config"
+)
+
+// Saving the price of the ad observed by the scrapper
+func (db *DB) RecordPrice(ctx context.Context, url string, price int) error {
+	_, err := db.exec(ctx, "RecordPrice", "INSERT INTO price_history (url, price, checked_at) values ($1, $2, $3)",
+		url, price, time.Now())
+	return err
+}
+
+// Returns all recorded prices of the ad, the oldest first
+func (db *DB) GetPriceHistory(ctx context.Context, url string) ([]config.PricePoint, error) {
+	points := make([]config.PricePoint, 0, 8)
+
+	rows, err := db.query(ctx, "GetPriceHistory", "SELECT url, price, checked_at FROM price_history WHERE url = $1 ORDER BY checked_at", url)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var point config.PricePoint
+		err = rows.Scan(&point.Url, &point.Price, &point.CheckedAt)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, rows.Err()
+}
+
+// Returns the latest price changes of all ads the email is subscribed to, the newest first
+func (db *DB) GetRecentPriceChanges(ctx context.Context, email string, limit int) ([]config.PriceChangeRecord, error) {
+	changes := make([]config.PriceChangeRecord, 0, limit)
+
+	rows, err := db.query(ctx, "GetRecentPriceChanges", `SELECT url, old_price, price, checked_at FROM (
+		SELECT url, price, checked_at, LAG(price) OVER (PARTITION BY url ORDER BY checked_at) AS old_price
+		FROM price_history WHERE url IN (SELECT url FROM subscription WHERE email = $1)
+	) history WHERE old_price IS NOT NULL AND old_price <> price ORDER BY checked_at DESC LIMIT $2`, email, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var change config.PriceChangeRecord
+		err = rows.Scan(&change.Url, &change.OldPrice, &change.NewPrice, &change.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
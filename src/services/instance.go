@@ -0,0 +1,29 @@
+package services
+
+import "context"
+
+// Saving the time of the last heartbeat of the scrapper instance. The first call registers it
+func (db *DB) Heartbeat(ctx context.Context, instanceId string) error {
+	_, err := db.exec(ctx, "Heartbeat", "INSERT INTO scrapper_instance (id, started_at, heartbeat_at) values ($1, now(), now()) "+
+		"ON CONFLICT (id) DO UPDATE SET heartbeat_at = now()",
+		instanceId)
+	if err != nil {
+		return err
+	}
+
+	// Instances that have been silent for a long time are forgotten, their leases are already taken by others
+	_, err = db.exec(ctx, "Heartbeat", "DELETE FROM scrapper_instance WHERE heartbeat_at < now() - make_interval(secs => $1)",
+		(10 * InstanceTimeout).Seconds())
+	return err
+}
+
+// Removing the stopped instance, checks leased by it are released for other instances
+func (db *DB) DeregisterInstance(ctx context.Context, instanceId string) error {
+	_, err := db.exec(ctx, "DeregisterInstance", "UPDATE scrape_job SET leased_by = NULL, lease_until = NULL WHERE leased_by = $1", instanceId)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.exec(ctx, "DeregisterInstance", "DELETE FROM scrapper_instance WHERE id = $1", instanceId)
+	return err
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"test_avito/src/metrics"
+	"test_avito/src/tracing"
+)
+
+// Every query of the datastore is made through these methods with the name of the operation,
+// for example "ClaimDueCheck". The query is a span "db.ClaimDueCheck" of the trace of ctx
+// and its duration is measured with this name
+
+func (db *DB) query(ctx context.Context, op string, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span, start := startQuery(ctx, op)
+	rows, err := db.QueryContext(ctx, query, args...)
+	endQuery(span, op, start, err)
+	return rows, err
+}
+
+func (db *DB) queryRow(ctx context.Context, op string, query string, args ...interface{}) *sql.Row {
+	ctx, span, start := startQuery(ctx, op)
+	row := db.QueryRowContext(ctx, query, args...)
+	// sql.ErrNoRows is an answer, not a failure of the query
+	err := row.Err()
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	endQuery(span, op, start, err)
+	return row
+}
+
+func (db *DB) exec(ctx context.Context, op string, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span, start := startQuery(ctx, op)
+	res, err := db.ExecContext(ctx, query, args...)
+	endQuery(span, op, start, err)
+	return res, err
+}
+
+func startQuery(ctx context.Context, op string) (context.Context, trace.Span, time.Time) {
+	ctx, span := tracing.Start(ctx, "db."+op,
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation.name", op))
+	return ctx, span, time.Now()
+}
+
+func endQuery(span trace.Span, op string, start time.Time, err error) {
+	metrics.DbQueryDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	tracing.End(span, err)
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"unicode/utf8"
+
+	"test_avito/config"
+)
+
+// Sizes of the columns of the listing table
+const (
+	listingTextSize     = 256
+	listingImageUrlSize = 512
+)
+
+// Saving the description of the ad. The previous description of the url is replaced.
+// Long texts are cut to the size of the columns, the image url is not saved if it is too long,
+// because a part of it is not a link
+func (db *DB) SaveListingInfo(ctx context.Context, url string, info config.ListingInfo) error {
+	info.Title = truncate(info.Title, listingTextSize)
+	info.Location = truncate(info.Location, listingTextSize)
+	info.Seller = truncate(info.Seller, listingTextSize)
+	if utf8.RuneCountInString(info.ImageUrl) > listingImageUrlSize {
+		info.ImageUrl = ""
+	}
+
+	var publishedAt sql.NullTime
+	if info.PublishedAt != nil {
+		publishedAt = sql.NullTime{Time: *info.PublishedAt, Valid: true}
+	}
+
+	_, err := db.exec(ctx, "SaveListingInfo", "INSERT INTO listing (url, title, image_url, location, seller, published_at, updated_at) "+
+		"values ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (url) DO UPDATE SET title = $2, image_url = $3, "+
+		"location = $4, seller = $5, published_at = $6, updated_at = $7",
+		url, info.Title, info.ImageUrl, info.Location, info.Seller, publishedAt, time.Now())
+	return err
+}
+
+// Function that converts columns of the listing table taken by LEFT JOIN. Nil if the ad has no description
+func listingInfoFromColumns(title, imageUrl, location, seller sql.NullString, publishedAt sql.NullTime) *config.ListingInfo {
+	if !title.Valid && !imageUrl.Valid && !location.Valid && !seller.Valid && !publishedAt.Valid {
+		return nil
+	}
+
+	info := &config.ListingInfo{
+		Title:    title.String,
+		ImageUrl: imageUrl.String,
+		Location: location.String,
+		Seller:   seller.String,
+	}
+	if publishedAt.Valid {
+		info.PublishedAt = &publishedAt.Time
+	}
+	return info
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"test_avito/config"
+	"test_avito/src/metrics"
+	"test_avito/src/tracing"
+)
+
+// Mail server of the service
+const (
+	smtpHost = "smtp.gmail.com"
+	smtpAddr = smtpHost + ":587"
+)
+
+// Mail account of the service, it is set from the config on the start
+var (
+	accountMu sync.RWMutex
+	account   config.Mail
+)
+
+func SetMailAccount(mail config.Mail) {
+	accountMu.Lock()
+	defer accountMu.Unlock()
+	account = mail
+}
+
+func mailAccount() config.Mail {
+	accountMu.RLock()
+	defer accountMu.RUnlock()
+	return account
+}
+
+// True if the mail account is set, without it notifications are not sent
+func MailAccountSet() bool {
+	return mailAccount().Address != ""
+}
+
+// Error of the mail which is not sent because the mail account is not set
+var ErrMailDisabled = errors.New("mail account is not set")
+
+const testMessage = "\nThis is a test mail of the avito price notification service"
+
+// Function that sends the test mail, so the operator can check the mail account of the service
+func SendTestMail(ctx context.Context, to string) error {
+	return deliverMail(ctx, "test", to, testMessage)
+}
+
+// Function that sends the mail from the account of the service. Every mail is a span of the trace
+// and is counted by the channel. ErrMailDisabled without the mail account, the SMTP server is not called then
+func deliverMail(ctx context.Context, channel string, to string, msg string) error {
+	if !MailAccountSet() {
+		return ErrMailDisabled
+	}
+
+	_, span := tracing.Start(ctx, "smtp.SendMail",
+		attribute.String("notification.channel", channel),
+		attribute.String("server.address", smtpHost))
+
+	from := mailAccount()
+	err := smtp.SendMail(smtpAddr,
+		smtp.PlainAuth(
+			"",
+			from.Address,
+			from.Password,
+			smtpHost),
+		from.Address, []string{to}, []byte(msg))
+
+	metrics.CountNotification(channel, err)
+	tracing.End(span, err)
+	return err
+}
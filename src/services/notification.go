@@ -1,42 +1,81 @@
 package services
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"net"
 	"net/smtp"
-	"os"
+	"strings"
+	"time"
 
 	"test_avito/config"
 )
 
 const (
-	sendMessage = "\nThe price of your item has changed!\nSee here: %s"
+	sendMessage        = "\nThe price of your item has changed!\nSee here: %s"
+	sendInfoMessage    = "\nThe price of your item \"%s\" has changed!\n%s\nSee here: %s"
+	newListingsMessage = "\nNew ads were found by your saved search %s:\n%s"
+	newListingLine     = "%d: %s\n"
+	operatorMessage    = "\n%s"
 )
 
 type DatastoreNotification interface {
-	SaveSubscription(config.Subscription) error
-	UpdateSubscription(subscription config.Subscription) error
-	GetAllUniqueUrlsAndPrices(chan config.CheckPriceRequest) error
-	GetEmailsByUrl(url string) ([]config.Subscription, error)
-	SendMessages(subs []config.Subscription)
+	PingContext(ctx context.Context) error
 
-	Confirm(hash string) (err error)
-	RecordMailConfirm(email string) (err error)
+	SaveSubscription(ctx context.Context, subscription config.Subscription) error
+	UpdateSubscription(ctx context.Context, subscription config.Subscription) error
+	GetEmailsByUrl(ctx context.Context, url string) ([]config.Subscription, error)
+	GetUrlByAdId(ctx context.Context, adId int64) (string, error)
+	GetSubscriptionsByEmail(ctx context.Context, email string) ([]config.Subscription, error)
+	CountSubscriptions(ctx context.Context, email string) (total int, unconfirmed int, err error)
+	DeleteSubscription(ctx context.Context, email string, url string) (bool, error)
+	SendMessages(ctx context.Context, subs []config.Subscription)
+	SendNewListingsMessages(ctx context.Context, subs []config.Subscription, listings []config.Listing)
+	SendOperatorAlert(ctx context.Context, email string, text string)
+	GetFailedNotifications(ctx context.Context) ([]config.FailedNotification, error)
+	ResendFailedNotification(ctx context.Context, id int64) error
+	SaveSeenListings(ctx context.Context, url string, listings []config.Listing) ([]config.Listing, error)
+	SaveListingInfo(ctx context.Context, url string, info config.ListingInfo) error
 
-	IsAuthorized(email string, authChan chan bool)
-	IsDuplicate(email string, url string, dupChan chan bool)
+	ScheduleCheck(ctx context.Context, url string, isSearch bool) error
+	RemoveCheck(ctx context.Context, url string) error
+	ClaimDueCheck(ctx context.Context, instanceId string) (config.CheckPriceRequest, bool, error)
+	CompleteCheck(ctx context.Context, url string, instanceId string, interval time.Duration, result config.CheckResult) error
+	RenewLease(ctx context.Context, url string, instanceId string) (bool, error)
+	CountDueChecks(ctx context.Context) (int, error)
+	RecheckNow(ctx context.Context, url string) (bool, error)
+	GetListingStatuses(ctx context.Context) ([]config.ListingStatus, error)
+	Heartbeat(ctx context.Context, instanceId string) error
+	DeregisterInstance(ctx context.Context, instanceId string) error
+
+	RecordPrice(ctx context.Context, url string, price int) error
+	GetPriceHistory(ctx context.Context, url string) ([]config.PricePoint, error)
+	GetRecentPriceChanges(ctx context.Context, email string, limit int) ([]config.PriceChangeRecord, error)
+
+	GetFeedToken(ctx context.Context, email string) (string, error)
+	GetEmailByFeedToken(ctx context.Context, token string) (string, error)
+
+	Confirm(ctx context.Context, hash string) (email string, err error)
+	RecordMailConfirm(ctx context.Context, email string) (err error)
+
+	IsAuthorized(ctx context.Context, email string, authChan chan bool)
+	IsDuplicate(ctx context.Context, email string, url string, dupChan chan bool)
 }
 
-func (db *DB) SaveSubscription(subscription config.Subscription) error {
-	_, err := db.Exec("INSERT INTO subscription (acc_verified, email, price, url) values ($1, $2, $3, $4)",
+func (db *DB) SaveSubscription(ctx context.Context, subscription config.Subscription) error {
+	_, err := db.exec(ctx, "SaveSubscription", "INSERT INTO subscription (acc_verified, email, price, url, ad_id, is_search) values ($1, $2, $3, $4, $5, $6)",
 		subscription.AccVerified,
 		subscription.Email,
 		subscription.Price,
-		subscription.Url)
+		subscription.Url,
+		subscription.AdId,
+		subscription.IsSearch)
 	return err
 }
 
-func (db *DB) UpdateSubscription(subscription config.Subscription) error {
-	_, err := db.Exec("UPDATE subscription SET acc_verified = $1, email = $2, price = $3, url = $4 WHERE email = $2 and url = $4",
+func (db *DB) UpdateSubscription(ctx context.Context, subscription config.Subscription) error {
+	_, err := db.exec(ctx, "UpdateSubscription", "UPDATE subscription SET acc_verified = $1, email = $2, price = $3, url = $4 WHERE email = $2 and url = $4",
 		subscription.AccVerified,
 		subscription.Email,
 		subscription.Price,
@@ -44,56 +83,135 @@ func (db *DB) UpdateSubscription(subscription config.Subscription) error {
 	return err
 }
 
-func (db *DB) GetAllUniqueUrlsAndPrices(pairChan chan config.CheckPriceRequest) error {
-	rows, err := db.Query("SELECT DISTINCT url, price FROM subscription where acc_verified = true")
+func (db *DB) GetEmailsByUrl(ctx context.Context, url string) ([]config.Subscription, error) {
+	subs := make([]config.Subscription, 0, 8)
+
+	rows, err := db.query(ctx, "GetEmailsByUrl", "SELECT acc_verified, email, price, url FROM subscription WHERE url = $1 and acc_verified = true", url)
 	defer rows.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for rows.Next() {
-		var pair config.CheckPriceRequest
-		err = rows.Scan(&pair.Url, &pair.OldPrice)
+		var sub config.Subscription
+		err = rows.Scan(&sub.AccVerified, &sub.Email, &sub.Price, &sub.Url)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		pairChan <- pair
+		subs = append(subs, sub)
 	}
-	return nil
+	return subs, nil
 }
 
-func (db *DB) GetEmailsByUrl(url string) ([]config.Subscription, error) {
+func (db *DB) GetSubscriptionsByEmail(ctx context.Context, email string) ([]config.Subscription, error) {
 	subs := make([]config.Subscription, 0, 8)
 
-	rows, err := db.Query("SELECT acc_verified, email, price, url FROM subscription WHERE url = $1 and acc_verified = true", url)
-	defer rows.Close()
+	rows, err := db.query(ctx, "GetSubscriptionsByEmail", "SELECT s.acc_verified, s.email, s.price, s.url, COALESCE(s.ad_id, 0), COALESCE(s.is_search, false), "+
+		"l.title, l.image_url, l.location, l.seller, l.published_at "+
+		"FROM subscription s LEFT JOIN listing l ON l.url = s.url WHERE s.email = $1 ORDER BY s.url", email)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var sub config.Subscription
-		err = rows.Scan(&sub.AccVerified, &sub.Email, &sub.Price, &sub.Url)
+		var title, imageUrl, location, seller sql.NullString
+		var publishedAt sql.NullTime
+		err = rows.Scan(&sub.AccVerified, &sub.Email, &sub.Price, &sub.Url, &sub.AdId, &sub.IsSearch,
+			&title, &imageUrl, &location, &seller, &publishedAt)
 		if err != nil {
 			return nil, err
 		}
+		sub.Info = listingInfoFromColumns(title, imageUrl, location, seller, publishedAt)
 		subs = append(subs, sub)
 	}
-	return subs, nil
+	return subs, rows.Err()
+}
+
+// Number of all subscriptions of the email and of the subscriptions waiting for the confirmation
+func (db *DB) CountSubscriptions(ctx context.Context, email string) (total int, unconfirmed int, err error) {
+	row := db.queryRow(ctx, "CountSubscriptions", "SELECT count(*), count(*) FILTER (WHERE NOT acc_verified) FROM subscription WHERE email = $1", email)
+	err = row.Scan(&total, &unconfirmed)
+	return total, unconfirmed, err
 }
 
-func (db *DB) SendMessages(subs []config.Subscription) {
-	serviceMail, _ := os.LookupEnv("service_mail")
-	password, _ := os.LookupEnv("password")
+// Removes the subscription of email to url. False if there was no such subscription
+func (db *DB) DeleteSubscription(ctx context.Context, email string, url string) (bool, error) {
+	res, err := db.exec(ctx, "DeleteSubscription", "DELETE FROM subscription WHERE email = $1 AND url = $2", email, url)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
 
+// Returns the url under which the ad is already stored, so that one ad is scraped once
+func (db *DB) GetUrlByAdId(ctx context.Context, adId int64) (string, error) {
+	row := db.queryRow(ctx, "GetUrlByAdId", "SELECT url FROM subscription WHERE ad_id = $1 LIMIT 1", adId)
+
+	var url string
+	err := row.Scan(&url)
+	return url, err
+}
+
+func (db *DB) SendMessages(ctx context.Context, subs []config.Subscription) {
 	for _, value := range subs {
 		msg := fmt.Sprintf(sendMessage, value.Url)
-		_ = smtp.SendMail("smtp.gmail.com:587",
-			smtp.PlainAuth(
-				"",
-				serviceMail,
-				password,
-				"smtp.gmail.com"),
-			serviceMail, []string{value.Email}, []byte(msg))
+		if value.Info != nil && value.Info.Title != "" {
+			msg = fmt.Sprintf(sendInfoMessage, value.Info.Title, listingDetails(value.Info), value.Url)
+		}
+		db.deliverOrKeep(ctx, "email", value.Email, value.Url, msg+db.feedFooter(ctx, value.Email))
+	}
+}
+
+// Function that joins known location, seller and publication date of the ad into one line
+func listingDetails(info *config.ListingInfo) string {
+	details := make([]string, 0, 3)
+	if info.Location != "" {
+		details = append(details, info.Location)
+	}
+	if info.Seller != "" {
+		details = append(details, "seller: "+info.Seller)
+	}
+	if info.PublishedAt != nil {
+		details = append(details, "published: "+info.PublishedAt.Format("02.01.2006"))
+	}
+	return strings.Join(details, ", ")
+}
+
+func (db *DB) SendNewListingsMessages(ctx context.Context, subs []config.Subscription, listings []config.Listing) {
+	var lines strings.Builder
+	for _, listing := range listings {
+		fmt.Fprintf(&lines, newListingLine, listing.Price, listing.Url)
+	}
+
+	for _, value := range subs {
+		msg := fmt.Sprintf(newListingsMessage, value.Url, lines.String())
+		db.deliverOrKeep(ctx, "email", value.Email, value.Url, msg+db.feedFooter(ctx, value.Email))
+	}
+}
+
+// Function that sends the alert about problems of the service to the operator
+func (db *DB) SendOperatorAlert(ctx context.Context, email string, text string) {
+	db.deliverOrKeep(ctx, "alert", email, "", fmt.Sprintf(operatorMessage, text))
+}
+
+// Function that checks that the mail server answers. Mails are not sent
+func CheckSmtp(timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", smtpAddr, timeout)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	client, err := smtp.NewClient(conn, smtpHost)
+	if err != nil {
+		conn.Close()
+		return err
 	}
+	return client.Quit()
 }
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"test_avito/config"
+)
+
+// A check taken by the instance is leased to it. If the instance dies, the check is taken by others
+// when the lease expires or the instance has not sent heartbeats for InstanceTimeout.
+// The lease of the running check is renewed every LeaseRenewInterval
+const (
+	leaseDuration      = 2 * time.Minute
+	LeaseRenewInterval = leaseDuration / 4
+	InstanceTimeout    = 30 * time.Second
+)
+
+// Adding the url to the queue of checks. The first check is done as soon as somebody confirmed the subscription
+func (db *DB) ScheduleCheck(ctx context.Context, url string, isSearch bool) error {
+	_, err := db.exec(ctx, "ScheduleCheck", "INSERT INTO scrape_job (url, is_search, next_check_at) values ($1, $2, now()) "+
+		"ON CONFLICT (url) DO NOTHING",
+		url, isSearch)
+	return err
+}
+
+// Removing the url from the queue if nobody is subscribed to it anymore
+func (db *DB) RemoveCheck(ctx context.Context, url string) error {
+	_, err := db.exec(ctx, "RemoveCheck", "DELETE FROM scrape_job WHERE url = $1 "+
+		"AND NOT EXISTS (SELECT 1 FROM subscription WHERE subscription.url = $1)",
+		url)
+	return err
+}
+
+// Taking one due check of the url with confirmed subscribers and leasing it to the instance, together with
+// the numbers used to plan the next check. SKIP LOCKED lets workers of all instances take different checks
+// at the same time. False if there are no due checks.
+// Active days are the days when the price differed from the previous one or new ads appeared in the search.
+// The price and the ads recorded at the subscription are not changes, so they are not counted
+func (db *DB) ClaimDueCheck(ctx context.Context, instanceId string) (config.CheckPriceRequest, bool, error) {
+	var pair config.CheckPriceRequest
+
+	row := db.queryRow(ctx, "ClaimDueCheck", "UPDATE scrape_job SET leased_by = $1, lease_until = now() + make_interval(secs => $2) "+
+		"WHERE url = (SELECT j.url FROM scrape_job j WHERE j.next_check_at <= now() "+
+		"AND (j.leased_by IS NULL OR j.lease_until < now() OR NOT EXISTS (SELECT 1 FROM scrapper_instance i "+
+		"WHERE i.id = j.leased_by AND i.heartbeat_at > now() - make_interval(secs => $3))) "+
+		"AND EXISTS (SELECT 1 FROM subscription s WHERE s.url = j.url AND s.acc_verified = true) "+
+		"ORDER BY j.next_check_at LIMIT 1 FOR UPDATE OF j SKIP LOCKED) "+
+		"RETURNING url, is_search, "+
+		"(SELECT COALESCE(MAX(price), 0) FROM subscription s WHERE s.url = scrape_job.url AND s.acc_verified = true), "+
+		"(SELECT count(*) FROM subscription s WHERE s.url = scrape_job.url AND s.acc_verified = true), "+
+		"CASE WHEN is_search THEN (SELECT count(DISTINCT date_trunc('day', h.seen_at)) FROM search_seen h "+
+		"WHERE h.url = scrape_job.url AND h.seen_at > now() - interval '30 days' "+
+		"AND h.seen_at > (SELECT MIN(f.seen_at) FROM search_seen f WHERE f.url = scrape_job.url)) "+
+		"ELSE (SELECT count(DISTINCT date_trunc('day', h.checked_at)) FROM (SELECT checked_at, price, "+
+		"LAG(price) OVER (ORDER BY checked_at) AS previous FROM price_history WHERE url = scrape_job.url) h "+
+		"WHERE h.previous IS NOT NULL AND h.price <> h.previous AND h.checked_at > now() - interval '30 days') END",
+		instanceId, leaseDuration.Seconds(), InstanceTimeout.Seconds())
+
+	err := row.Scan(&pair.Url, &pair.IsSearch, &pair.OldPrice, &pair.Subscribers, &pair.ActiveDays)
+	if err == sql.ErrNoRows {
+		return pair, false, nil
+	}
+	if err != nil {
+		return pair, false, err
+	}
+	return pair, true, nil
+}
+
+// Releasing the lease, recording the result and moving the check to the next time (interval with 10% of jitter).
+// Failures are counted in a row, skipped checks do not change them.
+// Nothing is changed if the lease was already taken by another instance
+func (db *DB) CompleteCheck(ctx context.Context, url string, instanceId string, interval time.Duration, result config.CheckResult) error {
+	_, err := db.exec(ctx, "CompleteCheck", "UPDATE scrape_job SET next_check_at = now() + make_interval(secs => $3 * (0.9 + random() * 0.2)), "+
+		"leased_by = NULL, lease_until = NULL, last_check_at = now(), last_status = $4, last_error = NULLIF($5, ''), "+
+		"failures = CASE $4 WHEN 'ok' THEN 0 WHEN 'skipped' THEN COALESCE(failures, 0) ELSE COALESCE(failures, 0) + 1 END "+
+		"WHERE url = $1 AND leased_by = $2",
+		url, instanceId, interval.Seconds(), result.Status, result.Error)
+	return err
+}
+
+// Extending the lease of the running check, so other instances do not take it while it lasts.
+// False if the lease was already taken by another instance
+func (db *DB) RenewLease(ctx context.Context, url string, instanceId string) (bool, error) {
+	res, err := db.exec(ctx, "RenewLease", "UPDATE scrape_job SET lease_until = now() + make_interval(secs => $3) "+
+		"WHERE url = $1 AND leased_by = $2",
+		url, instanceId, leaseDuration.Seconds())
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// Moving the check of the url to now, so a free worker takes it at once. False if the url is not in the queue
+func (db *DB) RecheckNow(ctx context.Context, url string) (bool, error) {
+	res, err := db.exec(ctx, "RecheckNow", "UPDATE scrape_job SET next_check_at = now() WHERE url = $1", url)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// States of all urls in the queue, the urls with most failures first
+func (db *DB) GetListingStatuses(ctx context.Context) ([]config.ListingStatus, error) {
+	statuses := make([]config.ListingStatus, 0, 16)
+
+	rows, err := db.query(ctx, "GetListingStatuses", "SELECT j.url, COALESCE(j.is_search, false), "+
+		"(SELECT count(*) FROM subscription s WHERE s.url = j.url), "+
+		"COALESCE(j.last_status, ''), COALESCE(j.last_error, ''), j.last_check_at, j.next_check_at, "+
+		"COALESCE(j.failures, 0), COALESCE(j.leased_by, '') "+
+		"FROM scrape_job j ORDER BY j.failures DESC NULLS LAST, j.url")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status config.ListingStatus
+		var lastCheckAt sql.NullTime
+		err = rows.Scan(&status.Url, &status.IsSearch, &status.Subscribers, &status.Status, &status.LastError,
+			&lastCheckAt, &status.NextCheckAt, &status.Failures, &status.LeasedBy)
+		if err != nil {
+			return nil, err
+		}
+		if lastCheckAt.Valid {
+			status.LastCheckAt = &lastCheckAt.Time
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, rows.Err()
+}
+
+// Number of due checks of urls with confirmed subscribers, including the checks taken by workers right now
+func (db *DB) CountDueChecks(ctx context.Context) (int, error) {
+	var count int
+	err := db.queryRow(ctx, "CountDueChecks", "SELECT count(*) FROM scrape_job j WHERE j.next_check_at <= now() "+
+		"AND EXISTS (SELECT 1 FROM subscription s WHERE s.url = j.url AND s.acc_verified = true)").Scan(&count)
+	return count, err
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"test_avito/config"
+)
+
+// Remembering ads of the saved search. Returns the ads that were not seen before
+func (db *DB) SaveSeenListings(ctx context.Context, url string, listings []config.Listing) ([]config.Listing, error) {
+	newListings := make([]config.Listing, 0, len(listings))
+	seenAt := time.Now()
+
+	for _, listing := range listings {
+		res, err := db.exec(ctx, "SaveSeenListings", "INSERT INTO search_seen (url, ad_id, price, seen_at) values ($1, $2, $3, $4) "+
+			"ON CONFLICT (url, ad_id) DO NOTHING",
+			url, listing.AdId, listing.Price, seenAt)
+		if err != nil {
+			return nil, err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if affected > 0 {
+			newListings = append(newListings, listing)
+		}
+	}
+	return newListings, nil
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"net"
+)
+
+// Networks which urls of the users must not reach: "this" network, private, shared, loopback,
+// link-local (with cloud metadata addresses), documentation, benchmark, multicast and reserved ones.
+// 6to4, Teredo and deprecated IPv4-compatible addresses carry an IPv4 address inside, the relay or the stack
+// may deliver them to a private host
+var forbiddenNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24",
+	"203.0.113.0/24", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "64:ff9b::/96", "100::/64", "2001:db8::/32", "fc00::/7", "fe80::/10", "ff00::/8",
+	"2001::/32", "2002::/16", "::/96",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// True if the address belongs to the internet. IPv4 addresses written as IPv6 are checked as IPv4
+func IsPublicIp(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, network := range forbiddenNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublicIp(t *testing.T) {
+	for _, ip := range []string{"87.245.199.1", "8.8.8.8", "2a00:1450:4010:c05::64"} {
+		assert.True(t, IsPublicIp(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.20.0.5", "192.168.1.1", "169.254.169.254",
+		"100.100.100.200", "0.0.0.0", "255.255.255.255", "::1", "::", "fd00:ec2::254", "fe80::1",
+		"::ffff:127.0.0.1", "::ffff:169.254.169.254"} {
+		assert.False(t, IsPublicIp(net.ParseIP(ip)), ip)
+	}
+	assert.False(t, IsPublicIp(nil))
+}
+
+func TestIsPublicIpRejects6to4(t *testing.T) {
+	// 2002:a9fe:a9fe:: carries 169.254.169.254
+	assert.False(t, IsPublicIp(net.ParseIP("2002:a9fe:a9fe::1")))
+	assert.False(t, IsPublicIp(net.ParseIP("2002:7f00:1::")))
+}
+
+func TestIsPublicIpRejectsTeredo(t *testing.T) {
+	// The client address of Teredo is 127.0.0.1 with inverted bits
+	assert.False(t, IsPublicIp(net.ParseIP("2001:0:4136:e378:8000:63bf:80ff:fffe")))
+	assert.False(t, IsPublicIp(net.ParseIP("2001::1")))
+}
+
+func TestIsPublicIpRejectsIpv4Compatible(t *testing.T) {
+	assert.False(t, IsPublicIp(net.ParseIP("::127.0.0.1")))
+	assert.False(t, IsPublicIp(net.ParseIP("::10.0.0.1")))
+	assert.False(t, IsPublicIp(net.ParseIP("::169.254.169.254")))
+}
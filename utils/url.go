@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const canonicalAvitoHost = "www.avito.ru"
+
+// Hosts under which the same ads are available
+var avitoHosts = map[string]bool{
+	"avito.ru":     true,
+	"www.avito.ru": true,
+	"m.avito.ru":   true,
+}
+
+// Query parameters that are added by the site for analytics and do not change the page
+var trackingParams = map[string]bool{
+	"context": true,
+	"src":     true,
+	"from":    true,
+}
+
+// Ad id is the numeric suffix of the last path segment: /moskva/avtomobili/bmw_m5_2019_1791027290
+var adIdRegexp = regexp.MustCompile(`(?:^|_)(\d{6,})$`)
+
+// True if url belongs to avito website
+func IsAvitoHost(host string) bool {
+	return avitoHosts[strings.ToLower(host)]
+}
+
+// Function that brings the url to a single form: mobile hosts are replaced with the desktop one,
+// tracking parameters, fragment and trailing slash are removed.
+// Returns canonical url and numeric id of the ad (0 if the url does not point to an avito ad)
+func CanonicalUrl(rawUrl string) (string, int64, error) {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return "", 0, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", 0, errors.New("url must be absolute")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.User = nil
+
+	query := u.Query()
+	for key := range query {
+		if strings.HasPrefix(key, "utm_") || trackingParams[key] {
+			query.Del(key)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+
+	var adId int64
+	if IsAvitoHost(u.Hostname()) {
+		u.Scheme = "https"
+		u.Host = canonicalAvitoHost
+		adId = parseAdId(u.Path)
+	}
+	return u.String(), adId, nil
+}
+
+func parseAdId(path string) int64 {
+	segment := path[strings.LastIndex(path, "/")+1:]
+	match := adIdRegexp.FindStringSubmatch(segment)
+	if match == nil {
+		return 0
+	}
+
+	id, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
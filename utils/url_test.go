@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalUrl(t *testing.T) {
+	cases := []struct {
+		raw       string
+		canonical string
+		adId      int64
+	}{
+		{"https://www.avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290",
+			"https://www.avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290", 1791027290},
+		{"https://m.avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290/",
+			"https://www.avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290", 1791027290},
+		{"http://avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290?utm_source=vk&context=H4sIAAAA#gallery",
+			"https://www.avito.ru/moskva/avtomobili/bmw_m5_2019_1791027290", 1791027290},
+		{"https://www.avito.ru/1791027290", "https://www.avito.ru/1791027290", 1791027290},
+		{"https://www.avito.ru/moskva/avtomobili/bmw/m5/2019?q=bmw&utm_campaign=x",
+			"https://www.avito.ru/moskva/avtomobili/bmw/m5/2019?q=bmw", 0},
+		{"https://Example.com/item/", "https://example.com/item", 0},
+	}
+
+	for _, c := range cases {
+		canonical, adId, err := CanonicalUrl(c.raw)
+		assert.Nil(t, err)
+		assert.Equal(t, c.canonical, canonical)
+		assert.Equal(t, c.adId, adId)
+	}
+}
+
+func TestCanonicalUrlNotAbsolute(t *testing.T) {
+	_, _, err := CanonicalUrl("vk.com")
+	assert.NotNil(t, err)
+}